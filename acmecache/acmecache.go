@@ -0,0 +1,68 @@
+package acmecache
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/Parquery/revproxyry/config"
+)
+
+// DefaultKeyPrefix prefixes the keys of the Redis cache if no prefix is configured.
+const DefaultKeyPrefix = "revproxyry:letsencrypt:"
+
+// New creates the cache of the Let's encrypt account and certificates as configured.
+//
+// The staging account and certificates are kept apart from the production ones, in the subdirectory "staging" or
+// under the key prefix extended with "staging:", respectively.
+func New(cfg *config.Config) (autocert.Cache, error) {
+	typ := config.LetsencryptCacheDir
+	if cfg.LetsencryptCache != nil && cfg.LetsencryptCache.Type != "" {
+		typ = cfg.LetsencryptCache.Type
+	}
+
+	switch typ {
+	case config.LetsencryptCacheDir:
+		dir := cfg.LetsencryptDir
+		if cfg.LetsencryptStaging {
+			dir = filepath.Join(dir, "staging")
+		}
+
+		return autocert.DirCache(dir), nil
+
+	case config.LetsencryptCacheRedis:
+		prefix := cfg.LetsencryptCache.KeyPrefix
+		if prefix == "" {
+			prefix = DefaultKeyPrefix
+		}
+
+		if cfg.LetsencryptStaging {
+			prefix += "staging:"
+		}
+
+		return NewRedis(cfg.LetsencryptCache.RedisURL, prefix)
+
+	default:
+		return nil, fmt.Errorf("unhandled type of the Let's encrypt cache: %#v", typ)
+	}
+}
+
+// Describe returns a human-readable description of the configured cache for the logs.
+func Describe(cfg *config.Config) string {
+	if cfg.LetsencryptCache != nil && cfg.LetsencryptCache.Type == config.LetsencryptCacheRedis {
+		u, err := config.ParseRedisURL(cfg.LetsencryptCache.RedisURL)
+		if err != nil {
+			return "Redis"
+		}
+
+		// The password is never logged.
+		return fmt.Sprintf("Redis at %s, database %d", u.Addr, u.DB)
+	}
+
+	if cfg.LetsencryptStaging {
+		return filepath.Join(cfg.LetsencryptDir, "staging")
+	}
+
+	return cfg.LetsencryptDir
+}
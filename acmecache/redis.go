@@ -0,0 +1,219 @@
+package acmecache
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/Parquery/revproxyry/config"
+)
+
+// redisTimeout limits a cache operation if the context has no earlier deadline.
+const redisTimeout = 10 * time.Second
+
+// maxBulkLength bounds the length of a value read from the server. The cached certificates and keys are only a few
+// kilobytes.
+const maxBulkLength = 16 << 20
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// Redis caches the Let's encrypt account and certificates in a Redis server so that they are shared by
+// the replicas of revproxyry.
+//
+// The private keys of the account and the certificates are stored unencrypted. Over the scheme redis, they are
+// also sent in cleartext, so rediss should be used unless the server is reachable only over a trusted network.
+//
+// A connection is established for each operation since the cache is only accessed when a certificate is obtained
+// or renewed, or when a replica starts.
+type Redis struct {
+	url    config.RedisURL
+	prefix string
+}
+
+// NewRedis creates the cache in the Redis server at the URL. The keys are prefixed with the prefix.
+func NewRedis(rawURL string, prefix string) (*Redis, error) {
+	u, err := config.ParseRedisURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the Redis URL: %s", err.Error())
+	}
+
+	return &Redis{url: u, prefix: prefix}, nil
+}
+
+// Get implements autocert.Cache.
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := r.do(ctx, "GET", r.prefix+key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := reply.(type) {
+	case nil:
+		return nil, autocert.ErrCacheMiss
+	case []byte:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unexpected reply of Redis to GET: %#v", reply)
+	}
+}
+
+// Put implements autocert.Cache.
+func (r *Redis) Put(ctx context.Context, key string, data []byte) error {
+	reply, err := r.do(ctx, "SET", r.prefix+key, string(data))
+	if err != nil {
+		return err
+	}
+
+	if reply != "OK" {
+		return fmt.Errorf("unexpected reply of Redis to SET: %#v", reply)
+	}
+
+	return nil
+}
+
+// Delete implements autocert.Cache.
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	_, err := r.do(ctx, "DEL", r.prefix+key)
+	return err
+}
+
+// do connects to the server, authenticates, selects the database and sends the command.
+func (r *Redis) do(ctx context.Context, args ...string) (interface{}, error) {
+	deadline := time.Now().Add(redisTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	dialer := &net.Dialer{Deadline: deadline}
+
+	var conn net.Conn
+	var err error
+	if r.url.UseTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: r.url.Host}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", r.url.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", r.url.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis at %s: %s", r.url.Addr, err.Error())
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	rd := bufio.NewReader(conn)
+
+	commands := [][]string{}
+	if r.url.Password != "" {
+		if r.url.Username != "" {
+			commands = append(commands, []string{"AUTH", r.url.Username, r.url.Password})
+		} else {
+			commands = append(commands, []string{"AUTH", r.url.Password})
+		}
+	}
+
+	if r.url.DB != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(r.url.DB)})
+	}
+
+	commands = append(commands, args)
+
+	var reply interface{}
+	for _, command := range commands {
+		_, err = conn.Write(encodeCommand(command))
+		if err != nil {
+			return nil, fmt.Errorf("failed to send %s to Redis: %s", command[0], err.Error())
+		}
+
+		reply, err = readReply(rd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute %s on Redis: %s", command[0], err.Error())
+		}
+	}
+
+	return reply, nil
+}
+
+// encodeCommand encodes the command as an array of bulk strings.
+func encodeCommand(args []string) []byte {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		b.WriteString(arg)
+		b.WriteString("\r\n")
+	}
+
+	return []byte(b.String())
+}
+
+// readReply reads a simple string, an integer or a bulk string. A null bulk string is returned as nil and
+// an error reply as redisError.
+func readReply(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(line, "\r\n") || len(line) < 3 {
+		return nil, fmt.Errorf("malformed reply: %#v", line)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+
+	case '-':
+		return nil, redisError(line[1:])
+
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed integer reply: %#v", line)
+		}
+		return n, nil
+
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 || n > maxBulkLength {
+			return nil, fmt.Errorf("unexpected length of the bulk reply: %#v", line)
+		}
+
+		if n == -1 {
+			return nil, nil
+		}
+
+		data := make([]byte, n+2)
+		_, err = io.ReadFull(rd, data)
+		if err != nil {
+			return nil, err
+		}
+
+		if string(data[n:]) != "\r\n" {
+			return nil, errors.New("the bulk reply is not terminated by CRLF")
+		}
+
+		return data[:n], nil
+
+	default:
+		return nil, fmt.Errorf("unexpected type of the reply: %#v", line)
+	}
+}
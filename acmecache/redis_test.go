@@ -0,0 +1,178 @@
+package acmecache
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestEncodeCommand(t *testing.T) {
+	got := string(encodeCommand([]string{"SET", "key", "multi\r\nline"}))
+	expected := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$11\r\nmulti\r\nline\r\n"
+
+	if got != expected {
+		t.Errorf("expected %#v, got %#v", expected, got)
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		reply       string
+		expected    interface{}
+		expectedErr string
+	}{
+		{reply: "+OK\r\n", expected: "OK"},
+		{reply: ":42\r\n", expected: int64(42)},
+		{reply: "$5\r\nhello\r\n", expected: []byte("hello")},
+		{reply: "$7\r\nab\r\ncde\r\n", expected: []byte("ab\r\ncde")},
+		{reply: "$0\r\n\r\n", expected: []byte{}},
+		{reply: "$-1\r\n", expected: nil},
+		{reply: "-ERR unknown command\r\n", expectedErr: "ERR unknown command"},
+		{reply: "+OK\n", expectedErr: `malformed reply: "+OK\n"`},
+		{reply: ":abc\r\n", expectedErr: `malformed integer reply: ":abc"`},
+		{reply: "$-2\r\n", expectedErr: `unexpected length of the bulk reply: "$-2"`},
+		{reply: "$999999999\r\n", expectedErr: `unexpected length of the bulk reply: "$999999999"`},
+		{reply: "$5\r\nhelloXX", expectedErr: "the bulk reply is not terminated by CRLF"},
+		{reply: "$5\r\nhel", expectedErr: "unexpected EOF"},
+		{reply: "*1\r\n$1\r\na\r\n", expectedErr: `unexpected type of the reply: "*1"`},
+		{reply: "", expectedErr: "EOF"},
+	}
+
+	for _, test := range tests {
+		got, err := readReply(bufio.NewReader(strings.NewReader(test.reply)))
+
+		switch {
+		case test.expectedErr == "" && err != nil:
+			t.Errorf("%#v: unexpected error: %s", test.reply, err.Error())
+
+		case test.expectedErr == "" && !reflect.DeepEqual(got, test.expected):
+			t.Errorf("%#v: expected %#v, got %#v", test.reply, test.expected, got)
+
+		case test.expectedErr != "" && err == nil:
+			t.Errorf("%#v: expected the error %#v, got none", test.reply, test.expectedErr)
+
+		case test.expectedErr != "" && err.Error() != test.expectedErr:
+			t.Errorf("%#v: expected the error %#v, got %#v", test.reply, test.expectedErr, err.Error())
+		}
+	}
+
+	_, err := readReply(bufio.NewReader(strings.NewReader("-WRONGPASS invalid password\r\n")))
+	if _, ok := err.(redisError); !ok {
+		t.Errorf("expected an error reply as redisError, got: %#v", err)
+	}
+}
+
+// serveRedis answers the commands on a single connection with the canned replies in order and records
+// the received commands.
+func serveRedis(t *testing.T, replies []string) (addr string, received chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received = make(chan string, len(replies))
+
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		rd := bufio.NewReader(conn)
+		for _, reply := range replies {
+			header, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "*")))
+			if err != nil {
+				return
+			}
+
+			// Each argument is a bulk string of two lines.
+			command := header
+			for i := 0; i < 2*n; i++ {
+				line, err := rd.ReadString('\n')
+				if err != nil {
+					return
+				}
+				command += line
+			}
+
+			received <- command
+			conn.Write([]byte(reply))
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func TestRedisGet(t *testing.T) {
+	addr, received := serveRedis(t, []string{"+OK\r\n", "+OK\r\n", "$4\r\ncert\r\n"})
+
+	r, err := NewRedis("redis://:secret@"+addr+"/2", "prefix:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := r.Get(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if string(data) != "cert" {
+		t.Errorf("expected the data %#v, got %#v", "cert", string(data))
+	}
+
+	expected := []string{
+		string(encodeCommand([]string{"AUTH", "secret"})),
+		string(encodeCommand([]string{"SELECT", "2"})),
+		string(encodeCommand([]string{"GET", "prefix:example.com"})),
+	}
+
+	for _, command := range expected {
+		if got := <-received; got != command {
+			t.Errorf("expected the command %#v, got %#v", command, got)
+		}
+	}
+}
+
+func TestRedisGetMiss(t *testing.T) {
+	addr, _ := serveRedis(t, []string{"$-1\r\n"})
+
+	r, err := NewRedis("redis://"+addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Get(context.Background(), "example.com")
+	if err != autocert.ErrCacheMiss {
+		t.Errorf("expected autocert.ErrCacheMiss, got: %v", err)
+	}
+}
+
+func TestRedisAuthFailure(t *testing.T) {
+	addr, _ := serveRedis(t, []string{"-WRONGPASS invalid username-password pair\r\n"})
+
+	r, err := NewRedis("redis://user:wrong@"+addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Put(context.Background(), "example.com", []byte("cert"))
+
+	expected := "failed to execute AUTH on Redis: WRONGPASS invalid username-password pair"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected the error %#v, got: %v", expected, err)
+	}
+}
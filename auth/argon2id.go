@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/Parquery/revproxyry/config"
+)
+
+// argon2idHash represents a parsed Argon2id hash in the PHC string format,
+// e.g., "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>".
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2id parses the Argon2id hash in the PHC string format.
+func parseArgon2id(passwordHash string) (*argon2idHash, error) {
+	parts := strings.Split(passwordHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, fmt.Errorf("expected 5 fields separated by $ in the Argon2id hash, got: %s", passwordHash)
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the version of the Argon2id hash: %s", parts[2])
+	}
+
+	if version != argon2.Version {
+		return nil, fmt.Errorf("unsupported version of the Argon2id hash: %d", version)
+	}
+
+	h := &argon2idHash{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the parameters of the Argon2id hash: %s", parts[3])
+	}
+
+	if h.time < 1 || h.threads < 1 {
+		return nil, fmt.Errorf("expected positive time and parallelism in the Argon2id hash: %s", parts[3])
+	}
+
+	if h.memory > config.MaxArgon2idMemoryKiB || h.time > config.MaxArgon2idTime {
+		return nil, fmt.Errorf("expected the memory of at most %d KiB and the time of at most %d "+
+			"in the Argon2id hash: %s", config.MaxArgon2idMemoryKiB, config.MaxArgon2idTime, parts[3])
+	}
+
+	h.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the salt of the Argon2id hash: %s", err.Error())
+	}
+
+	h.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the key of the Argon2id hash: %s", err.Error())
+	}
+
+	if len(h.key) == 0 {
+		return nil, fmt.Errorf("empty key in the Argon2id hash: %s", passwordHash)
+	}
+
+	return h, nil
+}
+
+// matches checks the password against the hash in constant time.
+func (h *argon2idHash) matches(password string) bool {
+	key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
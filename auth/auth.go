@@ -4,11 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 	htbasicauth "github.com/jimstudt/http-authentication/basic"
 
 	"github.com/Parquery/revproxyry/config"
+	"github.com/Parquery/revproxyry/jwt"
 )
 
 type hashing int
@@ -16,6 +18,7 @@ type hashing int
 const (
 	Apr1MD5 hashing = 0
 	Bcrypt          = 1
+	Argon2id        = 2
 )
 
 type Auth struct {
@@ -26,6 +29,9 @@ type Auth struct {
 
 	// md5 represents a parsed MD5 password generated by Apache's htpasswd. Only set if MD5.
 	md5 htbasicauth.EncodedPasswd
+
+	// argon2id represents a parsed Argon2id hash in the PHC string format. Only set if Argon2id.
+	argon2id *argon2idHash
 }
 
 // newAuth creates an authentication registry based on the authentication specified in the config.
@@ -49,6 +55,15 @@ func newAuth(username string, passwordHash string) (a *Auth, err error) {
 		strings.HasPrefix(passwordHash, "$2y$"):
 		a.hashing = Bcrypt
 
+	case strings.HasPrefix(passwordHash, "$argon2id$"):
+		a.hashing = Argon2id
+
+		a.argon2id, err = parseArgon2id(passwordHash)
+		if err != nil {
+			err = fmt.Errorf("failed to parse Argon2id hash: %s", err.Error())
+			return
+		}
+
 	default:
 		err = fmt.Errorf("unknown prefix of the password hash: %s", passwordHash)
 		return
@@ -62,6 +77,12 @@ type Auths struct {
 	// authentication registry maps user name -> list of authentications for this user.
 	registry map[string][]*Auth
 
+	// tokenHashes are the bcrypt hashes of the accepted bearer tokens.
+	tokenHashes []string
+
+	// verifiers verify the JSON Web Tokens presented as bearer tokens.
+	verifiers []*jwt.Verifier
+
 	// All indicates whether everybody is granted access.
 	All      bool
 }
@@ -79,7 +100,8 @@ func New(cfgAuths map[string]*config.Auth) (auths *Auths, err error) {
 		hasEmptyUsername := false
 
 		for _, cfgAuth := range cfgAuths {
-			if cfgAuth.Username == "" {
+			isBasic := cfgAuth.Type == "" || cfgAuth.Type == config.AuthTypeBasic
+			if isBasic && cfgAuth.Username == "" {
 				hasEmptyUsername = true
 			}
 		}
@@ -93,6 +115,31 @@ func New(cfgAuths map[string]*config.Auth) (auths *Auths, err error) {
 	auths.registry = make(map[string][]*Auth)
 
 	for id, cfgAuth := range cfgAuths {
+		if cfgAuth.Type == config.AuthTypeBearer {
+			for _, tokenHash := range cfgAuth.TokenHashes {
+				if !strings.HasPrefix(tokenHash, "$2a$") && !strings.HasPrefix(tokenHash, "$2y$") {
+					err = fmt.Errorf("expected a bcrypt hash of the token in the auth %s, got: %s", id, tokenHash)
+					return
+				}
+
+				auths.tokenHashes = append(auths.tokenHashes, tokenHash)
+			}
+			continue
+		}
+
+		if cfgAuth.Type == config.AuthTypeJWT {
+			var verifier *jwt.Verifier
+			verifier, err = jwt.New(cfgAuth)
+			if err != nil {
+				err = fmt.Errorf("failed to create a JWT verifier from the configuration of an auth %s: %s",
+					id, err.Error())
+				return
+			}
+
+			auths.verifiers = append(auths.verifiers, verifier)
+			continue
+		}
+
 		var auth *Auth
 		auth, err = newAuth(cfgAuth.Username, cfgAuth.PasswordHash)
 		if err != nil {
@@ -107,8 +154,87 @@ func New(cfgAuths map[string]*config.Auth) (auths *Auths, err error) {
 	return
 }
 
+// HasBasic indicates whether the registry contains basic authentications.
+func (aa *Auths) HasBasic() bool {
+	return len(aa.registry) > 0
+}
+
+// HasBearer indicates whether the registry contains bearer tokens or JWT verifiers.
+func (aa *Auths) HasBearer() bool {
+	return len(aa.tokenHashes) > 0 || len(aa.verifiers) > 0
+}
+
+// ClaimHeaders lists the headers under which the claims of the JWTs are forwarded.
+//
+// The headers need to be removed from the incoming requests so that the clients can not spoof them.
+func (aa *Auths) ClaimHeaders() []string {
+	var headers []string
+	for _, verifier := range aa.verifiers {
+		for _, header := range verifier.ForwardClaims {
+			headers = append(headers, header)
+		}
+	}
+
+	return headers
+}
+
+// AuthenticateBearer checks the presented bearer token against the JWT verifiers and the hashes of
+// the accepted tokens.
+//
+// If the token is a valid JWT, headers maps the headers to the values of the forwarded claims.
+// The semantics of ok, msg and err are the same as in Authenticate.
+func (aa *Auths) AuthenticateBearer(token string) (ok bool, msg string, headers map[string]string, err error) {
+	if aa.All {
+		ok = true
+		return
+	}
+
+	var jwtErr error
+	for _, verifier := range aa.verifiers {
+		var claims map[string]interface{}
+		claims, jwtErr = verifier.Verify(token, time.Now())
+		if jwtErr != nil {
+			continue
+		}
+
+		headers = make(map[string]string)
+		for claim, header := range verifier.ForwardClaims {
+			if value, has := claims[claim]; has {
+				headers[header] = jwt.ClaimValue(value)
+			}
+		}
+
+		ok = true
+		return
+	}
+
+	for _, tokenHash := range aa.tokenHashes {
+		err = bcrypt.CompareHashAndPassword([]byte(tokenHash), []byte(token))
+		switch {
+		case err == nil:
+			ok = true
+			return
+
+		case err == bcrypt.ErrMismatchedHashAndPassword:
+			err = nil
+
+		default:
+			return
+		}
+	}
+
+	msg = "invalid bearer token"
+	if jwtErr != nil && len(aa.tokenHashes) == 0 {
+		msg = fmt.Sprintf("invalid JWT: %s", jwtErr.Error())
+	}
+	return
+}
+
 // Authenticate checks whether the user is authentic by checking his/her password against the authentication registry.
 //
+// The authentication passes if the credentials match any of the users in the registry. If the same user name is
+// listed several times, any of its passwords is accepted.
+//
 // If the authentication passes, ok is set to true. In case that the authentication fails, ok is false and the message
 // indicates the reason of the authentication failure.
 //
@@ -125,6 +251,8 @@ func (aa *Auths) Authenticate(username string, password string) (ok bool, msg st
 		return
 	}
 
+	// The user may be listed in several auths of the route, e.g., with distinct passwords.
+	// The authentication succeeds if any of them matches.
 	for _, a := range authLst {
 		switch a.hashing {
 		case Apr1MD5:
@@ -133,9 +261,6 @@ func (aa *Auths) Authenticate(username string, password string) (ok bool, msg st
 				return
 			}
 
-			msg = "invalid password"
-			return
-
 		case Bcrypt:
 			err = bcrypt.CompareHashAndPassword([]byte(a.PasswordHash), []byte(password))
 			switch {
@@ -146,18 +271,22 @@ func (aa *Auths) Authenticate(username string, password string) (ok bool, msg st
 			case err == bcrypt.ErrMismatchedHashAndPassword:
 				// We need to void the error, since we set the message separately, and this case indicates that there was actually no authentication error.
 				err = nil
-				ok = false
-				msg = "invalid password"
-				return
 
 			default:
 				return
 			}
 
+		case Argon2id:
+			if a.argon2id.matches(password) {
+				ok = true
+				return
+			}
+
 		default:
 			panic(fmt.Sprintf("unhandled case: %d", a.hashing))
 		}
 	}
 
+	msg = "invalid password"
 	return
 }
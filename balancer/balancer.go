@@ -0,0 +1,305 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Parquery/revproxyry/circuitbreaker"
+	"github.com/Parquery/revproxyry/config"
+	"github.com/Parquery/revproxyry/retry"
+)
+
+// Backend represents a target URL of a route together with its health state.
+type Backend struct {
+	Target string
+	Proxy  *httputil.ReverseProxy
+
+	fails     int32 // atomic variable, number of consecutive failures
+	down      int32 // atomic variable, 1 if the backend has been ejected
+	downUntil int64 // atomic variable, Unix time in nanoseconds until which the backend is skipped
+
+	unhealthy int32 // atomic variable, 1 if the backend failed the active health probe
+}
+
+// available checks whether the backend has not been ejected by the passive health check at the given time.
+func (be *Backend) available(now time.Time) bool {
+	return now.UnixNano() >= atomic.LoadInt64(&be.downUntil)
+}
+
+// healthy checks whether the backend passed the last active health probe.
+func (be *Backend) healthy() bool {
+	return atomic.LoadInt32(&be.unhealthy) == 0
+}
+
+// Balancer distributes the requests among the backends in round-robin fashion.
+//
+// If the health check is specified, the backends failing repeatedly are ejected from the rotation.
+type Balancer struct {
+	backends []*Backend
+	counter  uint64 // atomic variable, increased on every request
+
+	maxFails    int32
+	failTimeout time.Duration
+
+	// retries is the maximum number of further backends tried by an idempotent request on a connection error.
+	retries int
+
+	prefix string
+	logOut *log.Logger
+	logErr *log.Logger
+}
+
+// attempt records the retryable error of a single attempt to proxy a request.
+type attempt struct {
+	err error
+}
+
+// attemptKey is the context key of the *attempt of a request which can still be retried.
+type attemptKey struct{}
+
+// New creates a balancer among the backends of the route with the given prefix.
+//
+// If the health check is given, the proxies of the backends are hooked so that the connection errors and
+// the responses with 5xx status codes count as failures.
+//
+// If retries is positive, an idempotent request failing with a connection error is retried on the next
+// backends up to retries times.
+func New(backends []*Backend, healthCheck *config.HealthCheck, retries int, prefix string,
+	logOut *log.Logger, logErr *log.Logger) *Balancer {
+
+	b := &Balancer{backends: backends, retries: retries, prefix: prefix, logOut: logOut, logErr: logErr}
+
+	if healthCheck == nil && retries == 0 {
+		return b
+	}
+
+	if healthCheck != nil {
+		b.maxFails = int32(healthCheck.MaxFails)
+		b.failTimeout = time.Duration(healthCheck.FailTimeoutSeconds) * time.Second
+	}
+
+	for _, be := range backends {
+		be := be
+
+		if healthCheck != nil {
+			modifyResponse := be.Proxy.ModifyResponse
+
+			be.Proxy.ModifyResponse = func(resp *http.Response) error {
+				if resp.StatusCode >= 500 {
+					b.reportFailure(be)
+				} else {
+					b.reportSuccess(be)
+				}
+
+				if modifyResponse != nil {
+					return modifyResponse(resp)
+				}
+				return nil
+			}
+		}
+
+		errorHandler := be.Proxy.ErrorHandler
+
+		be.Proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+			// The requests rejected by an open circuit have not been sent to the backend.
+			if healthCheck != nil && !errors.Is(err, circuitbreaker.ErrOpen) {
+				b.reportFailure(be)
+			}
+
+			// Nothing has been written yet so that the caller can retry the request on the next backend.
+			if a, ok := req.Context().Value(attemptKey{}).(*attempt); ok && retry.Retryable(err) {
+				b.logErr.Printf("Failed to proxy the request to %s: %s\n", be.Target, err.Error())
+				a.err = err
+				return
+			}
+
+			// The error handler of the proxy, if any, logs the error itself.
+			if errorHandler != nil {
+				errorHandler(w, req, err)
+				return
+			}
+
+			b.logErr.Printf("Failed to proxy the request to %s: %s\n", be.Target, err.Error())
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}
+
+	return b
+}
+
+// reportFailure counts the failure of the backend and ejects it if it failed too many times in a row.
+func (b *Balancer) reportFailure(be *Backend) {
+	fails := atomic.AddInt32(&be.fails, 1)
+	if fails < b.maxFails {
+		return
+	}
+
+	atomic.StoreInt32(&be.fails, 0)
+	atomic.StoreInt64(&be.downUntil, time.Now().Add(b.failTimeout).UnixNano())
+
+	if atomic.SwapInt32(&be.down, 1) == 0 {
+		b.logErr.Printf("Backend %s of the route %s is down after %d failure(s), skipping it for %s\n",
+			be.Target, b.prefix, fails, b.failTimeout.String())
+	}
+}
+
+// reportSuccess resets the failures of the backend and brings it back to the rotation.
+func (b *Balancer) reportSuccess(be *Backend) {
+	atomic.StoreInt32(&be.fails, 0)
+
+	if atomic.SwapInt32(&be.down, 0) == 1 {
+		b.logOut.Printf("Backend %s of the route %s is up again\n", be.Target, b.prefix)
+	}
+}
+
+// next picks the next healthy and available backend.
+//
+// If all the healthy backends have been ejected by the passive health check, the next healthy one is picked
+// regardless. If no backend is healthy, nil is returned.
+func (b *Balancer) next() *Backend {
+	now := time.Now()
+	n := uint64(len(b.backends))
+
+	for attempt := uint64(0); attempt < n; attempt++ {
+		be := b.backends[(atomic.AddUint64(&b.counter, 1)-1)%n]
+		if be.healthy() && be.available(now) {
+			return be
+		}
+	}
+
+	for attempt := uint64(0); attempt < n; attempt++ {
+		be := b.backends[(atomic.AddUint64(&b.counter, 1)-1)%n]
+		if be.healthy() {
+			return be
+		}
+	}
+
+	return nil
+}
+
+func (b *Balancer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	retries := b.retries
+	if !retry.Idempotent(req) {
+		retries = 0
+	}
+
+	for i := 0; ; i++ {
+		be := b.next()
+		if be == nil {
+			http.Error(w, "No healthy upstream", http.StatusBadGateway)
+			return
+		}
+
+		if i == retries {
+			be.Proxy.ServeHTTP(w, req)
+			return
+		}
+
+		a := &attempt{}
+		be.Proxy.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), attemptKey{}, a)))
+		if a.err == nil {
+			return
+		}
+
+		if req.Context().Err() != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		b.logErr.Printf("Retrying the request %s of the route %s on the next backend (retry %d of %d)\n",
+			req.RequestURI, b.prefix, i+1, retries)
+	}
+}
+
+// probe checks the health of the backend by requesting the path and expecting a 2xx or 3xx response.
+func (b *Balancer) probe(ctx context.Context, client *http.Client, be *Backend, path string) error {
+	u, err := url.Parse(be.Target)
+	if err != nil {
+		return err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// StartProbing spawns a goroutine per backend which actively probes its health until the context is done.
+//
+// A backend failing the probe is marked unhealthy immediately and does not receive any requests. It is
+// marked healthy again once it passed the probe healthy threshold times in a row.
+//
+// The probes use the transport of the route's proxies so that they share its TLS settings.
+func (b *Balancer) StartProbing(ctx context.Context, wg *sync.WaitGroup, activeHealth *config.ActiveHealth,
+	transport http.RoundTripper) {
+	interval := time.Duration(activeHealth.IntervalSeconds) * time.Second
+	client := &http.Client{Timeout: interval, Transport: transport}
+
+	for _, be := range b.backends {
+		be := be
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			successes := 0
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				err := b.probe(ctx, client, be, activeHealth.Path)
+
+				switch {
+				case ctx.Err() != nil:
+					// The probe has been interrupted by the shutdown.
+
+				case err != nil:
+					successes = 0
+
+					if atomic.SwapInt32(&be.unhealthy, 1) == 0 {
+						b.logErr.Printf("Backend %s of the route %s failed the health probe: %s\n",
+							be.Target, b.prefix, err.Error())
+					}
+
+				default:
+					successes++
+
+					if successes >= activeHealth.HealthyThreshold &&
+						atomic.SwapInt32(&be.unhealthy, 0) == 1 {
+						b.logOut.Printf("Backend %s of the route %s passed the health probe and is healthy again\n",
+							be.Target, b.prefix)
+					}
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+}
@@ -0,0 +1,261 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOpen is returned for the requests rejected by an open circuit. The requests have not been sent upstream.
+var ErrOpen = errors.New("the circuit to the upstream is open")
+
+// Window is the number of the most recent requests to an upstream over which the failure rate is computed.
+const Window = 20
+
+// MinRequests is the minimum number of the recent requests to an upstream before its circuit can open.
+const MinRequests = 10
+
+// State of a circuit
+type state int
+
+const (
+	// closed lets the requests through and tracks their outcomes.
+	closed state = iota
+
+	// open rejects the requests until the cool-down period passed.
+	open
+
+	// halfOpen lets a single trial request through; its outcome closes or re-opens the circuit.
+	halfOpen
+)
+
+// circuit tracks the outcomes of the recent requests to a single upstream.
+type circuit struct {
+	state state
+
+	// outcomes is a ring buffer of the recent outcomes, true for a failure.
+	outcomes [Window]bool
+	count    int // number of the recorded outcomes, at most Window
+	next     int // index of the next outcome in the ring buffer
+	failures int // number of the failures among the recorded outcomes
+
+	openUntil time.Time
+
+	// trial is set while the trial request of a half-open circuit is in flight.
+	trial bool
+}
+
+// record adds the outcome to the ring buffer.
+func (c *circuit) record(failed bool) {
+	if c.count == Window {
+		if c.outcomes[c.next] {
+			c.failures--
+		}
+	} else {
+		c.count++
+	}
+
+	c.outcomes[c.next] = failed
+	if failed {
+		c.failures++
+	}
+
+	c.next = (c.next + 1) % Window
+}
+
+// reset forgets the recorded outcomes.
+func (c *circuit) reset() {
+	*c = circuit{}
+}
+
+// Transport rejects the requests to the upstreams which failed at a high rate with ErrOpen.
+//
+// A circuit is kept per upstream host. It opens once the failures among the Window most recent requests reach
+// the threshold and rejects the requests for the cool-down period. Afterwards, a single trial request is let
+// through: on success, the circuit closes; on failure, it opens again.
+//
+// The errors of the underlying transport (e.g., dial errors, timeouts or the upstream closing the connection before
+// responding) and the responses with 5xx status codes count as failures. The requests whose body could not be read
+// from the client (e.g., exceeding the body limit) and the requests cancelled by the clients are not counted since
+// they tell nothing about the upstream.
+type Transport struct {
+	transport http.RoundTripper
+
+	threshold    float64
+	openDuration time.Duration
+
+	prefix string
+	logOut *log.Logger
+	logErr *log.Logger
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// New creates the circuit breaker around the transport of the route with the given prefix.
+func New(transport http.RoundTripper, threshold float64, openDuration time.Duration, prefix string,
+	logOut *log.Logger, logErr *log.Logger) *Transport {
+
+	return &Transport{
+		transport:    transport,
+		threshold:    threshold,
+		openDuration: openDuration,
+		prefix:       prefix,
+		logOut:       logOut,
+		logErr:       logErr,
+		circuits:     make(map[string]*circuit)}
+}
+
+// allow checks whether the request to the upstream can be sent. If the request is the trial of a half-open
+// circuit, trial is set.
+func (t *Transport) allow(upstream string, now time.Time) (allowed bool, trial bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.circuits[upstream]
+	if !ok {
+		return true, false
+	}
+
+	switch c.state {
+	case open:
+		if now.Before(c.openUntil) {
+			return false, false
+		}
+
+		c.state = halfOpen
+		c.trial = true
+		t.logOut.Printf("The circuit to the upstream %s of the route %s is half-open, "+
+			"letting a trial request through\n", upstream, t.prefix)
+		return true, true
+
+	case halfOpen:
+		if c.trial {
+			return false, false
+		}
+
+		c.trial = true
+		return true, true
+
+	default:
+		return true, false
+	}
+}
+
+// report records the outcome of the request to the upstream and transitions the circuit accordingly.
+func (t *Transport) report(upstream string, trial bool, failed bool, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.circuits[upstream]
+	if !ok {
+		c = &circuit{}
+		t.circuits[upstream] = c
+	}
+
+	if trial {
+		c.trial = false
+
+		if failed {
+			c.state = open
+			c.openUntil = now.Add(t.openDuration)
+			t.logErr.Printf("The trial request to the upstream %s of the route %s failed, "+
+				"the circuit is open again for %s\n", upstream, t.prefix, t.openDuration.String())
+			return
+		}
+
+		c.reset()
+		t.logOut.Printf("The circuit to the upstream %s of the route %s is closed again\n", upstream, t.prefix)
+		return
+	}
+
+	if c.state != closed {
+		// The requests let through before the circuit opened do not affect it anymore.
+		return
+	}
+
+	c.record(failed)
+
+	if c.count >= MinRequests && float64(c.failures) >= t.threshold*float64(c.count) {
+		t.logErr.Printf("The circuit to the upstream %s of the route %s is open after %d of %d recent "+
+			"requests failed, rejecting the requests for %s\n",
+			upstream, t.prefix, c.failures, c.count, t.openDuration.String())
+
+		c.state = open
+		c.openUntil = now.Add(t.openDuration)
+	}
+}
+
+// bodyReader records whether reading the request body from the client failed.
+type bodyReader struct {
+	io.ReadCloser
+
+	failed int32 // atomic variable, 1 if a read failed with an error other than io.EOF
+}
+
+// Read implements io.Reader.
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		atomic.StoreInt32(&b.failed, 1)
+	}
+
+	return n, err
+}
+
+// clientFailed checks whether the error was caused by the client rather than by the upstream.
+func clientFailed(req *http.Request, body *bodyReader, err error) bool {
+	if req.Context().Err() != nil {
+		return true
+	}
+
+	if body != nil && atomic.LoadInt32(&body.failed) == 1 {
+		return true
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	upstream := req.URL.Host
+
+	allowed, trial := t.allow(upstream, time.Now())
+	if !allowed {
+		return nil, ErrOpen
+	}
+
+	// The transport reports the errors of reading the request body just like the errors of the connection,
+	// so the reads are observed to tell them apart.
+	var body *bodyReader
+	if req.Body != nil && req.Body != http.NoBody {
+		body = &bodyReader{ReadCloser: req.Body}
+
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+
+	if err != nil && clientFailed(req, body, err) {
+		// The outcome tells nothing about the upstream. A trial is given up as well.
+		if trial {
+			t.mu.Lock()
+			if c, ok := t.circuits[upstream]; ok {
+				c.trial = false
+			}
+			t.mu.Unlock()
+		}
+
+		return resp, err
+	}
+
+	t.report(upstream, trial, err != nil || resp.StatusCode >= 500, time.Now())
+
+	return resp, err
+}
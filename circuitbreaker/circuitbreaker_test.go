@@ -0,0 +1,159 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func newTestTransport(transport http.RoundTripper) *Transport {
+	logger := log.New(ioutil.Discard, "", 0)
+	return New(transport, 0.5, time.Hour, "/", logger, logger)
+}
+
+func respond(status int) *http.Response {
+	return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader(""))}
+}
+
+func get(t *testing.T, transport http.RoundTripper) error {
+	req, err := http.NewRequest(http.MethodGet, "http://upstream.example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = transport.RoundTrip(req)
+	return err
+}
+
+func TestOpensOnDialErrors(t *testing.T) {
+	dialErr := errors.New("dial tcp: connection refused")
+	cb := newTestTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, dialErr
+	}))
+
+	for i := 0; i < MinRequests; i++ {
+		if err := get(t, cb); err != dialErr {
+			t.Fatalf("expected the dial error on request %d, got: %v", i, err)
+		}
+	}
+
+	if err := get(t, cb); err != ErrOpen {
+		t.Fatalf("expected ErrOpen after %d failures, got: %v", MinRequests, err)
+	}
+}
+
+func TestOpensOn5xx(t *testing.T) {
+	cb := newTestTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return respond(http.StatusBadGateway), nil
+	}))
+
+	for i := 0; i < MinRequests; i++ {
+		if err := get(t, cb); err != nil {
+			t.Fatalf("unexpected error on request %d: %v", i, err)
+		}
+	}
+
+	if err := get(t, cb); err != ErrOpen {
+		t.Fatalf("expected ErrOpen after %d responses with 502, got: %v", MinRequests, err)
+	}
+}
+
+func TestStaysClosedBelowThreshold(t *testing.T) {
+	i := 0
+	cb := newTestTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		i++
+		if i%3 == 0 {
+			return respond(http.StatusInternalServerError), nil
+		}
+		return respond(http.StatusOK), nil
+	}))
+
+	for j := 0; j < 3*Window; j++ {
+		if err := get(t, cb); err != nil {
+			t.Fatalf("expected the circuit to stay closed at a third of failures, got on request %d: %v", j, err)
+		}
+	}
+}
+
+// failingBody fails reading the request body as a body exceeding the limit.
+type failingBody struct{}
+
+func (failingBody) Read(p []byte) (int, error) {
+	return 0, &http.MaxBytesError{Limit: 10}
+}
+
+func (failingBody) Close() error {
+	return nil
+}
+
+func TestIgnoresRequestBodyErrors(t *testing.T) {
+	cb := newTestTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		// The transport reports the read error of the body as its own error, possibly unwrapped.
+		_, err := io.Copy(ioutil.Discard, req.Body)
+		return nil, errors.New(err.Error())
+	}))
+
+	for i := 0; i < 2*Window; i++ {
+		req, err := http.NewRequest(http.MethodPost, "http://upstream.example.com/", failingBody{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = cb.RoundTrip(req)
+		if err == ErrOpen {
+			t.Fatalf("expected the body errors of the client not to open the circuit, but it opened on request %d", i)
+		}
+	}
+}
+
+func TestIgnoresMaxBytesError(t *testing.T) {
+	cb := newTestTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, &http.MaxBytesError{Limit: 10}
+	}))
+
+	for i := 0; i < 2*Window; i++ {
+		if err := get(t, cb); err == ErrOpen {
+			t.Fatalf("expected MaxBytesError not to open the circuit, but it opened on request %d", i)
+		}
+	}
+}
+
+func TestTrialClosesCircuit(t *testing.T) {
+	failing := true
+	cb := newTestTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if failing {
+			return respond(http.StatusServiceUnavailable), nil
+		}
+		return respond(http.StatusOK), nil
+	}))
+	cb.openDuration = 0
+
+	for i := 0; i < MinRequests; i++ {
+		get(t, cb)
+	}
+
+	if cb.circuits["upstream.example.com"].state != open {
+		t.Fatalf("expected the circuit to be open")
+	}
+
+	failing = false
+	if err := get(t, cb); err != nil {
+		t.Fatalf("expected the trial request to pass, got: %v", err)
+	}
+
+	if cb.circuits["upstream.example.com"].state != closed {
+		t.Fatalf("expected the circuit to be closed after the successful trial")
+	}
+}
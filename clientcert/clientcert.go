@@ -0,0 +1,69 @@
+package clientcert
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Headers used to forward the client certificate to the upstream.
+const (
+	HeaderCert        = "X-Client-Cert"
+	HeaderSubjectCN   = "X-Client-Cert-Subject-Cn"
+	HeaderSAN         = "X-Client-Cert-San"
+	HeaderFingerprint = "X-Client-Cert-Fingerprint"
+)
+
+// Strip removes the client certificate headers from the request so that the client can not spoof them.
+func Strip(req *http.Request) {
+	req.Header.Del(HeaderCert)
+	req.Header.Del(HeaderSubjectCN)
+	req.Header.Del(HeaderSAN)
+	req.Header.Del(HeaderFingerprint)
+}
+
+// Forward sets the headers of the request to the verified client certificate.
+//
+// The certificate is PEM-encoded and URL-escaped since the header values can not contain new lines.
+// If withFields is set, the common name, the subject alternative names and the SHA-256 fingerprint of the
+// certificate are set as separate headers as well.
+//
+// Any client-supplied certificate headers are stripped first. If the client presented no verified certificate,
+// no header is set.
+func Forward(req *http.Request, withFields bool) {
+	Strip(req)
+
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return
+	}
+
+	cert := req.TLS.VerifiedChains[0][0]
+
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	req.Header.Set(HeaderCert, url.PathEscape(string(encoded)))
+
+	if !withFields {
+		return
+	}
+
+	req.Header.Set(HeaderSubjectCN, cert.Subject.CommonName)
+
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	if len(sans) > 0 {
+		req.Header.Set(HeaderSAN, strings.Join(sans, ","))
+	}
+
+	fingerprint := sha256.Sum256(cert.Raw)
+	req.Header.Set(HeaderFingerprint, hex.EncodeToString(fingerprint[:]))
+}
@@ -0,0 +1,92 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Resolver resolves the IP of the client behind the trusted proxies.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// New parses the CIDRs of the trusted proxies.
+func New(cidrs []string) (*Resolver, error) {
+	r := &Resolver{}
+
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR of a trusted proxy %#v: %s", cidr, err.Error())
+		}
+
+		r.trusted = append(r.trusted, ipNet)
+	}
+
+	return r, nil
+}
+
+func (r *Resolver) isTrusted(ip net.IP) bool {
+	for _, ipNet := range r.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unixPeer checks whether the request came over a Unix domain socket without a PROXY protocol header,
+// i.e., the peer has no IP.
+func unixPeer(req *http.Request, peer net.IP) bool {
+	if peer != nil {
+		return false
+	}
+
+	addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// Resolve returns the IP of the client.
+//
+// If the direct peer is a trusted proxy, the X-Forwarded-For header is followed from the right to
+// the first untrusted entry. Otherwise, the header is ignored since it might be spoofed. If the client
+// could not be resolved from the header, an empty string is returned.
+//
+// A peer connected over a Unix domain socket is always trusted since only the local processes permitted to
+// access the socket, i.e., the proxies in front, can connect.
+func (r *Resolver) Resolve(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	peer := net.ParseIP(host)
+	if !unixPeer(req, peer) && (peer == nil || !r.isTrusted(peer)) {
+		return ""
+	}
+
+	var entries []string
+	for _, value := range req.Header.Values("X-Forwarded-For") {
+		entries = append(entries, strings.Split(value, ",")...)
+	}
+
+	resolved := ""
+	for i := len(entries) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(entries[i]))
+		if ip == nil {
+			// A malformed entry can not be trusted, so neither can the entries left of it.
+			break
+		}
+
+		resolved = ip.String()
+
+		if !r.isTrusted(ip) {
+			break
+		}
+	}
+
+	return resolved
+}
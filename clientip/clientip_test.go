@@ -0,0 +1,66 @@
+package clientip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func newRequest(t *testing.T, remoteAddr string, localAddr net.Addr, forwardedFor string) *http.Request {
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.RemoteAddr = remoteAddr
+	if localAddr != nil {
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, localAddr))
+	}
+
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+
+	return req
+}
+
+func TestResolve(t *testing.T) {
+	r, err := New([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcp := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80}
+	unix := &net.UnixAddr{Name: "/run/revproxyry.sock", Net: "unix"}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		localAddr    net.Addr
+		forwardedFor string
+		expected     string
+	}{
+		{name: "trusted peer", remoteAddr: "10.1.2.3:1234", localAddr: tcp,
+			forwardedFor: "198.51.100.1", expected: "198.51.100.1"},
+		{name: "trusted chain", remoteAddr: "10.1.2.3:1234", localAddr: tcp,
+			forwardedFor: "203.0.113.9, 198.51.100.1, 10.2.3.4", expected: "198.51.100.1"},
+		{name: "untrusted peer", remoteAddr: "192.0.2.1:1234", localAddr: tcp,
+			forwardedFor: "198.51.100.1", expected: ""},
+		{name: "unix peer", remoteAddr: "@", localAddr: unix,
+			forwardedFor: "198.51.100.1", expected: "198.51.100.1"},
+		{name: "unix peer with empty address", remoteAddr: "", localAddr: unix,
+			forwardedFor: "198.51.100.2", expected: "198.51.100.2"},
+		{name: "PROXY protocol over unix", remoteAddr: "192.0.2.1:1234", localAddr: unix,
+			forwardedFor: "198.51.100.1", expected: ""},
+		{name: "no IP over TCP", remoteAddr: "@", localAddr: tcp,
+			forwardedFor: "198.51.100.1", expected: ""},
+	}
+
+	for _, test := range tests {
+		got := r.Resolve(newRequest(t, test.remoteAddr, test.localAddr, test.forwardedFor))
+		if got != test.expected {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.expected, got)
+		}
+	}
+}
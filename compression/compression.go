@@ -0,0 +1,241 @@
+package compression
+
+import (
+	"bufio"
+	"compress/gzip"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MinSize is the size in bytes below which the responses are not compressed since the gain does not pay off.
+const MinSize = 1024
+
+// AcceptsGzip checks whether the client accepts the gzip content encoding.
+func AcceptsGzip(req *http.Request) bool {
+	for _, value := range req.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(value, ",") {
+			parts := strings.Split(item, ";")
+			coding := strings.ToLower(strings.TrimSpace(parts[0]))
+			if coding != "gzip" && coding != "*" {
+				continue
+			}
+
+			accepted := true
+			for _, param := range parts[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					q, err := strconv.ParseFloat(param[2:], 64)
+					accepted = err == nil && q > 0
+				}
+			}
+
+			if accepted {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Compressible checks whether the content type benefits from compression.
+//
+// The already compressed types such as images, videos and archives are not compressible.
+func Compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/json", "application/javascript", "application/x-javascript", "application/xml",
+		"application/wasm", "image/svg+xml", "font/ttf", "font/otf":
+		return true
+	}
+
+	return false
+}
+
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+// Handler compresses the responses with gzip if the client accepts it.
+type Handler struct {
+	Handler http.Handler
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if req.Method == http.MethodHead || !AcceptsGzip(req) {
+		h.Handler.ServeHTTP(w, req)
+		return
+	}
+
+	gw := &responseWriter{ResponseWriter: w}
+	defer gw.close()
+
+	h.Handler.ServeHTTP(gw, req)
+}
+
+// responseWriter buffers the beginning of the response until it can decide whether to compress it.
+type responseWriter struct {
+	http.ResponseWriter
+
+	statusCode  int
+	wroteHeader bool // true if the header has been passed to the underlying writer
+	buf         []byte
+	gz          *gzip.Writer // nil unless compressing
+}
+
+func (rw *responseWriter) WriteHeader(statusCode int) {
+	if statusCode < 200 {
+		// Informational responses are passed through, the final header follows.
+		rw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	if rw.statusCode == 0 {
+		rw.statusCode = statusCode
+	}
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.statusCode == 0 {
+		rw.statusCode = http.StatusOK
+	}
+
+	if !rw.wroteHeader {
+		rw.buf = append(rw.buf, b...)
+		if len(rw.buf) < MinSize {
+			return len(b), nil
+		}
+
+		err := rw.start()
+		if err != nil {
+			return 0, err
+		}
+
+		return len(b), nil
+	}
+
+	if rw.gz != nil {
+		return rw.gz.Write(b)
+	}
+
+	return rw.ResponseWriter.Write(b)
+}
+
+// shouldCompress decides whether to compress the response based on its header and the buffered beginning.
+func (rw *responseWriter) shouldCompress() bool {
+	header := rw.Header()
+
+	if len(rw.buf) < MinSize ||
+		rw.statusCode == http.StatusNoContent ||
+		rw.statusCode == http.StatusNotModified ||
+		rw.statusCode == http.StatusPartialContent ||
+		header.Get("Content-Encoding") != "" ||
+		header.Get("Content-Range") != "" {
+		return false
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(rw.buf)
+		header.Set("Content-Type", contentType)
+	}
+
+	return Compressible(contentType)
+}
+
+// start writes the header and the buffered beginning of the response, compressed if appropriate.
+func (rw *responseWriter) start() error {
+	rw.wroteHeader = true
+
+	if rw.shouldCompress() {
+		header := rw.Header()
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+
+		// The compressed representation is not byte-identical, so a strong validator must not be reused.
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		rw.gz = gzipWriters.Get().(*gzip.Writer)
+		rw.gz.Reset(rw.ResponseWriter)
+	}
+
+	rw.ResponseWriter.WriteHeader(rw.statusCode)
+
+	buf := rw.buf
+	rw.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if rw.gz != nil {
+		_, err = rw.gz.Write(buf)
+	} else {
+		_, err = rw.ResponseWriter.Write(buf)
+	}
+
+	return err
+}
+
+// close writes the rest of the response and releases the gzip writer.
+func (rw *responseWriter) close() {
+	if !rw.wroteHeader {
+		if rw.statusCode == 0 {
+			// Nothing has been written by the handler.
+			return
+		}
+
+		rw.start()
+	}
+
+	if rw.gz != nil {
+		rw.gz.Close()
+		gzipWriters.Put(rw.gz)
+		rw.gz = nil
+	}
+}
+
+// Flush sends the buffered data to the client, e.g., for the streamed responses.
+func (rw *responseWriter) Flush() {
+	if !rw.wroteHeader {
+		if rw.statusCode == 0 {
+			rw.statusCode = http.StatusOK
+		}
+
+		rw.start()
+	}
+
+	if rw.gz != nil {
+		rw.gz.Flush()
+	}
+
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Hijack hands over the connection, e.g., for the protocol upgrades.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(rw.ResponseWriter).Hijack()
+}
+
+// Unwrap gives http.ResponseController access to the underlying response writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
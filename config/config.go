@@ -1,50 +1,1180 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"os"
+	"io"
 	"io/ioutil"
-	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Types of the authentication
+const (
+	AuthTypeBasic  = "basic"
+	AuthTypeBearer = "bearer"
+	AuthTypeJWT    = "jwt"
+)
+
+// Upper bounds of the parameters of the Argon2id password hashes so that a hash can not make every
+// authentication allocate or compute excessively.
+const (
+	MaxArgon2idMemoryKiB = 1 << 20 // 1 GiB
+	MaxArgon2idTime      = 64
+)
+
+// Auth represents an authentication by a tuple (username, password hash), by bearer tokens or by JSON Web Tokens.
+type Auth struct {
+	/* type of the authentication, "basic", "bearer" or "jwt". If empty, "basic" is assumed */
+	Type string `json:"type"`
+
+	/* user name to authenticate. If empty and the type is basic, no authentication */
+	Username string `json:"username"`
+
+	/* hash of the password. Use revproxyhashry to hash it */
+	PasswordHash string `json:"password_hash"`
+
+	/* bcrypt hashes of the accepted bearer tokens. Only used if the type is bearer */
+	TokenHashes []string `json:"token_hashes"`
+
+	/*
+	key verifying the signatures of the JWTs: either a shared HMAC secret or a PEM-encoded public key.
+	Only used if the type is jwt
+	*/
+	SigningKey string `json:"signing_key"`
+
+	/* URL of the JSON Web Key Set verifying the signatures of the JWTs. Only used if the type is jwt */
+	JWKSURL string `json:"jwks_url"`
+
+	/* expected issuer and audience of the JWTs. If empty, not checked */
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+
+	/* if set, the JWTs without the expiration claim are accepted. By default, they are rejected */
+	AllowMissingExp bool `json:"allow_missing_exp"`
+
+	/* maps the claims of the JWT to the headers under which they are forwarded to the upstream */
+	ForwardClaims map[string]string `json:"forward_claims"`
+}
+
+// Route represents a route of a reverse proxy.
+type Route struct {
+	/* Route prefix */
+	Prefix string `json:"prefix"`
+
+	/*
+	regular expression matched against the request path.
+	If set, the route is selected by the expression instead of the prefix; the prefix, if any, is still stripped.
+	*/
+	Match string `json:"match"`
+
+	/* if false, the prefix is not stripped and the full request path is passed on. If nil, the prefix is stripped */
+	StripPrefix *bool `json:"strip_prefix"`
+
+	/*
+	path to the target.
+	If a directory, everything beneath it will be served beneath the prefix.
+	If an URL, proxies the requests to that URL after stripping the prefix.
+	*/
+	Target  string   `json:"target"`
+	AuthIDs []string `json:"auths"`
+
+	/*
+	URL (or absolute path) to which the requests are redirected instead of being served. The remainder of the path
+	after the prefix and the query are appended. Can not be combined with target or targets
+	*/
+	RedirectTo string `json:"redirect_to"`
+
+	/* status code of the redirect to redirect_to; one of 301, 302, 307 or 308. If 0, defaults to 301 */
+	RedirectStatus int `json:"redirect_status"`
+
+	/* if set, the route requires no authentication. Can not be combined with auths */
+	Public bool `json:"public"`
+
+	/* realm shown in the authentication prompt. If empty, the realm of the config is used */
+	Realm string `json:"realm"`
+
+	/* if set, the compressible responses are compressed with gzip if the client accepts it */
+	Compression bool `json:"compression"`
+
+	/* file served when a directory is requested. If empty, "index.html" is used. Only for directory targets */
+	IndexFile string `json:"index_file"`
+
+	/* if false, a directory without the index file is not listed, but 404. If nil, listed. Only for directory targets */
+	DirectoryListing *bool `json:"directory_listing"`
+
+	/*
+	if set, a request for a missing path without a file extension is served the index file of the target
+	directory, e.g., for the client-side routes of single-page apps. Only for directory targets
+	*/
+	SPAFallback bool `json:"spa_fallback"`
+
+	/* Cache-Control header of the served files. If empty, none is sent. Only for directory targets */
+	CacheControl string `json:"cache_control"`
+
+	/*
+	regular expression matched against the path of the served files, e.g., "\\.[0-9a-f]{8,}\\.(js|css)$".
+	The matching files (e.g., with hashed names) are cached for a year as immutable. Only for directory targets
+	*/
+	ImmutablePattern string `json:"immutable_pattern"`
+
+	/*
+	URLs of the targets among which the requests are balanced in round-robin fashion.
+	Mutually exclusive with Target.
+	*/
+	Targets []string `json:"targets"`
+
+	/* time windows in which the route is accessible. If nil, the route is always accessible */
+	AccessSchedule *AccessSchedule `json:"access_schedule"`
+
+	/* if set, the verified TLS client certificate is forwarded to the upstream as a header */
+	ForwardClientCert bool `json:"forward_client_cert"`
+
+	/* if set, the common name, SANs and fingerprint of the client certificate are forwarded as well */
+	ForwardClientCertFields bool `json:"forward_client_cert_fields"`
+
+	/*
+	handling of the percent-encoded slashes ("%2F") in the request path.
+	One of "reject", "decode" or "raw". If empty, defaults to "reject" for directories and "raw" for URLs.
+	*/
+	EncodedSlashes string `json:"encoded_slashes"`
+
+	/* passive health check of the targets. If nil, the targets are never ejected */
+	HealthCheck *HealthCheck `json:"health_check"`
+
+	/*
+	if set, the Domain attribute of the Set-Cookie headers from the upstream is rewritten to the domain and
+	the Path attribute is prepended with the prefix
+	*/
+	RewriteCookies bool `json:"rewrite_cookies"`
+
+	/*
+	headers set on the requests to the upstream and on the responses from the upstream, respectively.
+	Empty values remove the header. Only applicable to URL targets.
+	*/
+	RequestHeaders  map[string]string `json:"request_headers"`
+	ResponseHeaders map[string]string `json:"response_headers"`
+
+	/* timeout in seconds for connecting to the upstream. If 0, defaults to 30 seconds */
+	DialTimeoutSeconds int `json:"dial_timeout_seconds"`
+
+	/* timeout in seconds for waiting on the response headers of the upstream. If 0, there is no timeout */
+	ResponseHeaderTimeoutSeconds int `json:"response_header_timeout_seconds"`
+
+	/*
+	maximum number of retries of the idempotent requests (GET and HEAD without a body) on a connection error.
+	With multiple targets, the retries go to the next targets. If 0, the requests are not retried
+	*/
+	Retries int `json:"retries"`
+
+	/* TLS settings of the connections to the https:// upstreams. If nil, the upstreams are fully verified */
+	UpstreamTLS *UpstreamTLS `json:"upstream_tls"`
+
+	/* if false, the Host header sent upstream is the host of the target. If nil, the client's Host is preserved */
+	PreserveHost *bool `json:"preserve_host"`
+
+	/* if set, the Host header sent upstream. Can not be combined with preserve_host */
+	UpstreamHost string `json:"upstream_host"`
+
+	/* if set, the write timeout of the server does not apply to the route, e.g., for streaming large files */
+	DisableWriteTimeout bool `json:"disable_write_timeout"`
+
+	/* rewrite of the request path after stripping the prefix. If nil, the path is not rewritten */
+	Rewrite *Rewrite `json:"rewrite"`
+
+	/* active health probing of the targets. If nil, the targets are not probed */
+	ActiveHealth *ActiveHealth `json:"active_health"`
+
+	/* rate limit of the requests per client IP. If nil, the requests are not rate-limited */
+	RateLimit *RateLimit `json:"rate_limit"`
+
+	/* maximum size of the request bodies in bytes. If 0, max_request_body_bytes of the config applies */
+	MaxRequestBodyBytes int64 `json:"max_request_body_bytes"`
+
+	/* pool of the idle connections to the upstreams. If 0, the respective setting of the config applies */
+	MaxIdleConns           int `json:"max_idle_conns"`
+	MaxIdleConnsPerHost    int `json:"max_idle_conns_per_host"`
+	IdleConnTimeoutSeconds int `json:"idle_conn_timeout_seconds"`
+
+	/* allowed request methods (e.g., "GET", "HEAD"); the others are rejected with 405. If empty, all are allowed */
+	Methods []string `json:"methods"`
+
+	/* security headers of the route's responses. If nil, the security_headers of the config apply */
+	SecurityHeaders *SecurityHeaders `json:"security_headers"`
+
+	/*
+	fraction between 0 and 1 of the successful requests logged in the access log. The 4xx and 5xx responses
+	are always logged. If nil, all the requests are logged
+	*/
+	LogSampleRate *float64 `json:"log_sample_rate"`
+
+	/* if set, the request and response headers are included in the access log with the credentials redacted */
+	LogHeaders bool `json:"log_headers"`
+
+	/* in-memory cache of the upstream responses to GET requests. If nil, the responses are not cached */
+	Cache *ResponseCache `json:"cache"`
+
+	/*
+	if set, the route tunnels the CONNECT requests to the allowed destinations as a forward proxy instead of
+	serving a target. Such a route has neither a prefix nor a target
+	*/
+	ForwardProxy *ForwardProxy `json:"forward_proxy"`
+
+	/*
+	if set, the requests to a target failing at a high rate are rejected with 503 for a cool-down period.
+	Only for the URL targets
+	*/
+	CircuitBreaker *CircuitBreaker `json:"circuit_breaker"`
+}
+
+// CircuitBreaker represents how the requests to a failing target of a route are cut off.
+type CircuitBreaker struct {
+	/*
+	fraction between 0 and 1 of the failed recent requests to a target at which its circuit opens.
+	The connection errors and the responses with 5xx status codes count as failures
+	*/
+	FailureThreshold float64 `json:"failure_threshold"`
+
+	/* duration in seconds for which an open circuit rejects the requests before a trial request is let through */
+	OpenSeconds int `json:"open_seconds"`
+}
+
+// ForwardProxy represents the tunneling of the CONNECT requests to the allowed destinations.
+type ForwardProxy struct {
+	/* destination host names. A wildcard such as "*.example.com" covers a single label */
+	AllowedHosts []string `json:"allowed_hosts"`
+
+	/* destination ports. If empty, only 443 is allowed */
+	AllowedPorts []int `json:"allowed_ports"`
+}
+
+// ResponseCache represents the in-memory cache of the upstream responses of a route.
+type ResponseCache struct {
+	/* maximum number of cached responses; the least recently used ones are evicted. If 0, 1000 is assumed */
+	MaxEntries int `json:"max_entries"`
+
+	/* maximum size of a cached response body in bytes. The larger responses are not cached. If 0, 1 MiB is assumed */
+	MaxEntryBytes int64 `json:"max_entry_bytes"`
+
+	/*
+	if set, the responses are cached even though the route requires authentication. Mind that all the authorized
+	users then share the cached responses
+	*/
+	AllowAuthenticated bool `json:"allow_authenticated"`
+
+	/*
+	time in seconds for which an expired response is kept and served with "Warning: 110" if the upstream fails
+	(e.g., times out or responds with 500, 502, 503 or 504). If 0, the expired responses are never served
+	*/
+	StaleIfErrorSeconds int `json:"stale_if_error_seconds"`
+}
+
+// RateLimit represents a token bucket limiting the rate of requests of a single client IP.
+type RateLimit struct {
+	/* rate at which the bucket refills */
+	RequestsPerSecond float64 `json:"requests_per_second"`
+
+	/* size of the bucket, i.e. the number of requests a client can make at once */
+	Burst int `json:"burst"`
+}
+
+// SecurityHeaders represents the security headers added to the responses. Empty fields are omitted.
+type SecurityHeaders struct {
+	/* value of X-Content-Type-Options, e.g., "nosniff" */
+	ContentTypeOptions string `json:"x_content_type_options"`
+
+	/* value of X-Frame-Options, e.g., "DENY" or "SAMEORIGIN" */
+	FrameOptions string `json:"x_frame_options"`
+
+	/* value of Referrer-Policy, e.g., "strict-origin-when-cross-origin" */
+	ReferrerPolicy string `json:"referrer_policy"`
+
+	/* value of Content-Security-Policy, e.g., "default-src 'self'" */
+	ContentSecurityPolicy string `json:"content_security_policy"`
+}
+
+// HSTS represents the Strict-Transport-Security header sent on the HTTPS responses.
+type HSTS struct {
+	/* how long the browsers should access the domain only over HTTPS */
+	MaxAgeSeconds int `json:"max_age_seconds"`
+
+	/* if set, the policy applies to the subdomains as well */
+	IncludeSubdomains bool `json:"include_subdomains"`
+
+	/* if set, the domain consents to be included in the preload lists of the browsers */
+	Preload bool `json:"preload"`
+}
+
+// UpstreamTLS represents the TLS settings of the connections to the upstreams of a route.
+type UpstreamTLS struct {
+	/* if set, the certificates of the upstreams are not verified. Use only for testing */
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+
+	/* PEM file of the CA certificates which verify the upstreams in addition to the system pool */
+	CAPath string `json:"ca_path"`
+
+	/* PEM files of the client certificate and its key presented to the upstreams (mTLS) */
+	CertPath string `json:"cert_path"`
+	KeyPath  string `json:"key_path"`
+}
+
+// Rewrite represents a rewrite of the request path before proxying it to the upstream.
+type Rewrite struct {
+	/* regular expression matched against the path, e.g., "^/api/v1/(.*)$" */
+	Pattern string `json:"pattern"`
+
+	/* replacement of the matched path which can refer to the capture groups, e.g., "/$1" */
+	Replacement string `json:"replacement"`
+}
+
+// ActiveHealth represents how the targets of a route are periodically probed for their health.
+type ActiveHealth struct {
+	/* path requested on each target, e.g., "/healthz" */
+	Path string `json:"path"`
+
+	/* interval between two probes in seconds */
+	IntervalSeconds int `json:"interval_seconds"`
+
+	/* number of consecutive successful probes after which an unhealthy target is considered healthy again */
+	HealthyThreshold int `json:"healthy_threshold"`
+}
+
+// HealthCheck represents how the failing targets of a route are ejected from the rotation.
+type HealthCheck struct {
+	/* number of consecutive failures after which a target is ejected */
+	MaxFails int `json:"max_fails"`
+
+	/* duration in seconds for which an ejected target is skipped before it is tried again */
+	FailTimeoutSeconds int `json:"fail_timeout_seconds"`
+}
+
+// Handling of the percent-encoded slashes in the request path
+const (
+	// EncodedSlashesReject rejects the requests with encoded slashes.
+	EncodedSlashesReject = "reject"
+
+	// EncodedSlashesDecode decodes the encoded slashes before passing on the request.
+	EncodedSlashesDecode = "decode"
+
+	// EncodedSlashesRaw passes the encoded slashes as-are to the upstream.
+	EncodedSlashesRaw = "raw"
+)
+
+// LogTargetSyslog sends the logs to the syslog.
+const LogTargetSyslog = "syslog"
+
+// SyslogFacilities maps the names of the syslog facilities to their codes as defined in RFC 5424.
+var SyslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// Formats of the logged requests
+const (
+	// LogFormatJSON logs a JSON object per request.
+	LogFormatJSON = "json"
+
+	// LogFormatPlain logs a concise line per request.
+	LogFormatPlain = "plain"
+
+	// LogFormatCombined logs in the Combined Log Format of Apache.
+	LogFormatCombined = "combined"
+)
+
+// AllTargets lists the targets of the route regardless whether they were given as Target or Targets.
+func (r *Route) AllTargets() []string {
+	if r.Target != "" {
+		return []string{r.Target}
+	}
+
+	return r.Targets
+}
+
+// AccessWindow represents a time window of an access schedule.
+type AccessWindow struct {
+	/* days of the week ("mon", "tue", ...). If empty, the window applies on all days */
+	Days []string `json:"days"`
+
+	/* start and end of the window given as HH:MM. If the end precedes the start, the window spans midnight */
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// AccessSchedule represents the time windows in which a route is accessible.
+type AccessSchedule struct {
+	/* IANA timezone of the windows. If empty, UTC is assumed */
+	Timezone string         `json:"timezone"`
+	Windows  []AccessWindow `json:"windows"`
+}
+
+// Certificate represents a certificate/key pair selected by the server name indicated by the client (SNI).
+type Certificate struct {
+	CertPath string `json:"cert_path"`
+	KeyPath  string `json:"key_path"`
+
+	/* host names covered by the certificate. A wildcard such as "*.example.com" covers a single label */
+	Hostnames []string `json:"hostnames"`
+}
+
+// Backends of the Let's encrypt cache
+const (
+	// LetsencryptCacheDir caches in letsencrypt_dir on the local disk.
+	LetsencryptCacheDir = "dir"
+
+	// LetsencryptCacheRedis caches in a Redis server shared by the replicas.
+	LetsencryptCacheRedis = "redis"
 )
 
-// Auth represents an authentication by a tuple (username, password hash).
-type Auth struct {
-	/* user name to authenticate. If empty, no authentication */
-	Username string `json:"username"`
+// LetsencryptCache represents the backend caching the Let's encrypt account and certificates.
+type LetsencryptCache struct {
+	/* "dir" (default) or "redis" */
+	Type string `json:"type"`
+
+	/*
+	URL of the Redis server such as "redis://:password@localhost:6379/0", where the path selects the database.
+	Use the scheme "rediss" to connect over TLS; with "redis", the private keys are sent in cleartext
+	*/
+	RedisURL string `json:"redis_url"`
+
+	/* prefix of the Redis keys. If empty, "revproxyry:letsencrypt:" is assumed */
+	KeyPrefix string `json:"key_prefix"`
+}
+
+// Config represents a parsed config JSON file.
+type Config struct {
+	Auths          map[string]*Auth `json:"auths"`
+	Domain         string           `json:"domain"`
+	Routes         []Route          `json:"routes"`
+	SslKeyPath     string           `json:"ssl_key_path"`
+	SslCertPath    string           `json:"ssl_cert_path"`
+	LetsencryptDir string           `json:"letsencrypt_dir"`
+
+	/*
+	if set, the certificates are requested from the staging environment of Let's encrypt which issues untrusted
+	certificates under much higher rate limits. Its data is kept in the subdirectory "staging" of letsencrypt_dir
+	*/
+	LetsencryptStaging bool `json:"letsencrypt_staging"`
+
+	/*
+	backend caching the Let's encrypt account and certificates, e.g., a Redis server shared by several replicas.
+	If nil, they are cached in letsencrypt_dir
+	*/
+	LetsencryptCache *LetsencryptCache `json:"letsencrypt_cache"`
+
+	/* contact email address registered with the Let's encrypt account, if any */
+	LetsencryptEmail string `json:"letsencrypt_email"`
+
+	/*
+	further host names, besides the domain, for which Let's encrypt certificates are obtained. A wildcard such as
+	"*.example.com" covers a single label
+	*/
+	LetsencryptHosts []string `json:"letsencrypt_hosts"`
+
+	/*
+	passphrase decrypting the key of ssl_key_path if it is encrypted (legacy PEM encryption or PKCS #8).
+	Reference an environment variable (e.g., "${SSL_KEY_PASSPHRASE}") to keep it out of the config
+	*/
+	SslKeyPassphrase string `json:"ssl_key_passphrase"`
+
+	/*
+	if set, the OCSP response of the issuer's responder is stapled to the certificate of ssl_cert_path.
+	The certificate file needs to contain the issuer after the leaf
+	*/
+	OCSPStapling bool `json:"ocsp_stapling"`
+
+	/*
+	path to the PEM-encoded CA certificates against which the TLS client certificates are verified.
+	The clients without a certificate are still served. Needed by forward_client_cert
+	*/
+	ClientCAPath string `json:"client_ca_path"`
+
+	/* if set, the HTTPS responses carry the Strict-Transport-Security header */
+	HSTS *HSTS `json:"hsts"`
+
+	/*
+	target (a directory or an URL) handling the requests matched by no route. It is served without authentication.
+	Can not be combined with a route with the prefix "/". If empty, the unmatched requests are answered with 404
+	*/
+	DefaultTarget string `json:"default_target"`
+
+	/*
+	custom error pages served instead of the plain-text messages, mapping the status code (e.g., "502") to the
+	path of the page. Applies to the upstream failures, to the paths without a route and to the errors of the file server
+	*/
+	ErrorPages map[string]string `json:"error_pages"`
+
+	/* security headers of the responses of all the routes which do not specify their own */
+	SecurityHeaders *SecurityHeaders `json:"security_headers"`
+
+	/* if true, the TRACE requests are rejected with 405 before they reach any route. If nil, defaults to true */
+	BlockTrace *bool `json:"block_trace"`
+
+	/* if set, the Server and X-Powered-By headers are removed from all the responses, e.g., set by the upstreams */
+	StripServerHeaders bool `json:"strip_server_headers"`
+
+	/* certificates selected by SNI. The ssl_cert_path and ssl_key_path pair, if any, is the fallback */
+	Certificates []Certificate `json:"certificates"`
+
+	/* addresses of the HTTP and HTTPS servers, either host:port or unix:/path/to.sock for a Unix domain socket */
+	HttpAddress    string           `json:"http_address"`
+	HttpsAddress   string           `json:"https_address"`
+
+	/* default realm shown in the authentication prompts. If empty, "Restricted" is used */
+	Realm string `json:"realm"`
+
+	/*
+	number of failed authentications of a client IP within the window after which the client is blocked
+	for the cooldown. If 0, the failed authentications are not limited
+	*/
+	AuthMaxFailures int `json:"auth_max_failures"`
+
+	/* window and cooldown in seconds. If 0, the window defaults to 60 seconds and the cooldown to the window */
+	AuthFailureWindowSeconds int `json:"auth_failure_window_seconds"`
+	AuthCooldownSeconds      int `json:"auth_cooldown_seconds"`
+
+	/*
+	If set and SSL is used, the HTTP server serves the routes directly instead of
+	redirecting to HTTPS.
+	*/
+	DisableHttpsRedirect bool `json:"disable_https_redirect"`
+
+	/*
+	status code of the redirects from HTTP to HTTPS; one of 301, 302, 307 or 308. If 0, defaults to 301.
+	Mind that 301 and 302 make most clients turn a POST into a GET, while 307 and 308 preserve the method and the body.
+	*/
+	RedirectStatus int `json:"redirect_status"`
+
+	/* if false, the HTTPS server only speaks HTTP/1.1. If nil, HTTP/2 is negotiated via ALPN */
+	HTTP2 *bool `json:"http2"`
+
+	/* timeouts of the servers in seconds. If 0, the write timeout defaults to 300 seconds and the others to 60 */
+	ReadTimeoutSeconds       int `json:"read_timeout_seconds"`
+	ReadHeaderTimeoutSeconds int `json:"read_header_timeout_seconds"`
+	WriteTimeoutSeconds      int `json:"write_timeout_seconds"`
+	IdleTimeoutSeconds       int `json:"idle_timeout_seconds"`
+
+	/* idle timeout of the HTTP/2 connections in seconds. If 0, idle_timeout_seconds applies to HTTP/2 as well */
+	HTTP2IdleTimeoutSeconds int `json:"http2_idle_timeout_seconds"`
+
+	/* time in seconds given to the requests in flight to finish on shutdown. If 0, defaults to 30 seconds */
+	ShutdownTimeoutSeconds int `json:"shutdown_timeout_seconds"`
+
+	/*
+	time in seconds for which the servers keep accepting new connections on shutdown while the health probes
+	already fail so that the load balancers stop sending new requests. If 0, the servers stop accepting right away
+	*/
+	ShutdownDelaySeconds int `json:"shutdown_delay_seconds"`
+
+	/*
+	CIDRs of the proxies in front of revproxyry (e.g., load balancers). If the direct peer is trusted,
+	the client IP is resolved from the X-Forwarded-For header.
+	*/
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	/*
+	if set, the connections to the HTTP and HTTPS servers need to start with a PROXY protocol header (version 1 or 2),
+	e.g., behind an AWS Network Load Balancer, and the client address is taken from it
+	*/
+	ProxyProtocol bool `json:"proxy_protocol"`
+
+	/*
+	glob patterns of the files whose routes and auths are merged into the config.
+	The relative patterns are resolved against the directory of the config file.
+	*/
+	Include []string `json:"include"`
+
+	/* header carrying the ID of a request to the upstream, the response and the log. If empty, "X-Request-Id" */
+	RequestIDHeader string `json:"request_id_header"`
+
+	/* maximum number of requests handled simultaneously. If 0, unlimited */
+	MaxConcurrentRequests int `json:"max_concurrent_requests"`
+
+	/* maximum size of the request bodies in bytes, overridable per route. If 0, unlimited */
+	MaxRequestBodyBytes int64 `json:"max_request_body_bytes"`
+
+	/*
+	maximum size of the request line and headers in bytes. The larger requests are rejected with 431 and logged.
+	If 0, defaults to 1 MiB
+	*/
+	MaxHeaderBytes int `json:"max_header_bytes"`
+
+	/*
+	pool of the idle connections to the upstreams, overridable per route. If 0, Go's defaults apply
+	(100 connections in total, 2 per host, closed after 90 seconds)
+	*/
+	MaxIdleConns           int `json:"max_idle_conns"`
+	MaxIdleConnsPerHost    int `json:"max_idle_conns_per_host"`
+	IdleConnTimeoutSeconds int `json:"idle_conn_timeout_seconds"`
+
+	/* address on which to serve the Prometheus metrics at /metrics. If empty, no metrics are collected */
+	MetricsAddress string `json:"metrics_address"`
+
+	/* address on which to serve the liveness (/healthz) and readiness (/readyz) probes. If empty, not served */
+	HealthAddress string `json:"health_address"`
+
+	/* paths to the access and error log files. If empty, logs to the standard output and error, respectively */
+	AccessLogPath string `json:"access_log_path"`
+	ErrorLogPath  string `json:"error_log_path"`
+
+	/* size in megabytes after which a log file is rotated. If 0, the log files are not rotated */
+	LogMaxSizeMB int `json:"log_max_size_mb"`
+
+	/*
+	destination of the logs: "syslog" or, if empty, the standard output and error.
+	Can not be combined with access_log_path and error_log_path
+	*/
+	LogTarget string `json:"log_target"`
+
+	/* facility of the syslog messages (e.g., "daemon" or "local0"). If empty, "daemon" */
+	SyslogFacility string `json:"syslog_facility"`
+
+	/* tag of the syslog messages. If empty, "revproxyry" */
+	SyslogTag string `json:"syslog_tag"`
+
+	/*
+	format of the logged requests: "json", "plain" or "combined" (Apache). If empty, "json".
+	The other log lines are always free-form text
+	*/
+	LogFormat string `json:"log_format"`
+
+	/* if false, the log lines are written without the "revproxyry: <timestamp>: " prefix. If nil, they are prefixed */
+	LogPrefix *bool `json:"log_prefix"`
+}
+
+// unixPrefix marks an address as the path to a Unix domain socket.
+const unixPrefix = "unix:"
+
+// UnixSocketPath returns the path of the Unix domain socket if the address is given as unix:/path/to.sock.
+func UnixSocketPath(addr string) (pth string, ok bool) {
+	if !strings.HasPrefix(addr, unixPrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(addr, unixPrefix), true
+}
+
+// UsesLetsencrypt indicates whether the certificates are obtained from Let's encrypt.
+func UsesLetsencrypt(cfg *Config) bool {
+	return cfg.LetsencryptDir != "" || cfg.LetsencryptCache != nil
+}
+
+// UsesSSL indicates whether the config specifies an HTTPS server.
+func UsesSSL(cfg *Config) bool {
+	return cfg.SslCertPath != "" || len(cfg.Certificates) > 0 || UsesLetsencrypt(cfg)
+}
+
+// methodRe matches the request methods, e.g., GET or M-SEARCH.
+var methodRe = regexp.MustCompile(`^[A-Z][A-Z0-9-]*$`)
+
+// checkReadable checks that the path is a directory or a regular file which the process can read.
+func checkReadable(pth string) error {
+	// The path is stat'ed before opening it since opening a named pipe would block.
+	stat, err := os.Stat(pth)
+	if err != nil {
+		return err
+	}
+
+	if !stat.IsDir() && !stat.Mode().IsRegular() {
+		return fmt.Errorf("expected a directory or a regular file, got: %s", pth)
+	}
+
+	f, err := os.Open(pth)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if stat.IsDir() {
+		// Opening a directory succeeds even without the permission to list it.
+		_, err = f.Readdirnames(1)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to list the directory %s: %s", pth, err.Error())
+		}
+
+		return nil
+	}
+
+	_, err = f.Read(make([]byte, 1))
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read the file %s: %s", pth, err.Error())
+	}
+
+	return nil
+}
+
+// validateForwardProxy validates the i-th route which tunnels the CONNECT requests.
+func validateForwardProxy(i int, route Route) error {
+	if route.Prefix != "" || route.Match != "" {
+		return fmt.Errorf("the forward proxy Route %d can not have a prefix or a match "+
+			"since the CONNECT requests have no path", i)
+	}
+
+	if len(route.AllTargets()) > 0 || route.RedirectTo != "" {
+		return fmt.Errorf("the forward proxy Route %d can not have a target, targets or redirect_to", i)
+	}
+
+	if route.Cache != nil || route.Compression || route.Rewrite != nil || len(route.Methods) > 0 {
+		return fmt.Errorf("cache, compression, rewrite and methods can not be used with the forward proxy Route %d", i)
+	}
+
+	if route.CircuitBreaker != nil {
+		return fmt.Errorf("circuit_breaker can not be used with the forward proxy Route %d", i)
+	}
+
+	if route.Public && len(route.AuthIDs) > 0 {
+		return fmt.Errorf("the forward proxy Route %d is public, but lists auths: %#v", i, route.AuthIDs)
+	}
+
+	if len(route.ForwardProxy.AllowedHosts) == 0 {
+		return fmt.Errorf("expected at least one host in allowed_hosts of the forward proxy Route %d", i)
+	}
+
+	for _, host := range route.ForwardProxy.AllowedHosts {
+		if host == "" || host == "*." || strings.ContainsAny(strings.TrimPrefix(host, "*."), ":/*") {
+			return fmt.Errorf("invalid host in allowed_hosts of the forward proxy Route %d, "+
+				"expected a host name or a wildcard such as \"*.example.com\": %#v", i, host)
+		}
+	}
+
+	for _, port := range route.ForwardProxy.AllowedPorts {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port in allowed_ports of the forward proxy Route %d: %d", i, port)
+		}
+	}
+
+	if route.DialTimeoutSeconds < 0 {
+		return fmt.Errorf("expected non-negative dial_timeout_seconds for the forward proxy Route %d, got: %d",
+			i, route.DialTimeoutSeconds)
+	}
+
+	return nil
+}
+
+// Validate validates the parsed config.
+func Validate(cfg *Config) error {
+	// prefixes collects the prefixes of the routes selected by the prefix, i.e., without a match.
+	prefixes := make(map[string]bool)
+
+	forwardProxies := 0
+
+	for i, route := range cfg.Routes {
+		if route.ForwardProxy != nil {
+			forwardProxies++
+			if forwardProxies > 1 {
+				return fmt.Errorf("expected at most one Route with forward_proxy, got at least %d", forwardProxies)
+			}
+
+			err := validateForwardProxy(i, route)
+			if err != nil {
+				return err
+			}
+
+			for _, authID := range route.AuthIDs {
+				if _, ok := cfg.Auths[authID]; !ok {
+					return fmt.Errorf(
+						"Auth could not be found in the list of auths for the forward proxy Route %d: %#v",
+						i, authID)
+				}
+			}
+
+			continue
+		}
+
+		if route.Prefix == "" && route.Match == "" {
+			return fmt.Errorf("empty prefix of the Route %d with the target(s) %s",
+				i, strings.Join(route.AllTargets(), ", "))
+		}
+
+		if route.Prefix != "" && !strings.HasPrefix(route.Prefix, "/") {
+			return fmt.Errorf("expected the prefix of the Route %d to start with a slash, got: %#v", i, route.Prefix)
+		}
+
+		if route.Match == "" {
+			if prefixes[route.Prefix] {
+				return fmt.Errorf("multiple Routes share the prefix %s", route.Prefix)
+			}
+			prefixes[route.Prefix] = true
+		}
+
+		if route.Target != "" && len(route.Targets) > 0 {
+			return fmt.Errorf("both target and targets were specified for the Route with prefix %s",
+				route.Prefix)
+		}
+
+		targets := route.AllTargets()
+		if len(targets) == 0 && route.RedirectTo == "" {
+			return fmt.Errorf("no target was specified for the Route with prefix %s", route.Prefix)
+		}
+
+		if route.RedirectTo != "" {
+			if len(targets) > 0 {
+				return fmt.Errorf("both redirect_to and target(s) were specified for the Route with prefix %s",
+					route.Prefix)
+			}
+
+			if !strings.HasPrefix(route.RedirectTo, "/") {
+				u, err := url.ParseRequestURI(route.RedirectTo)
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+					return fmt.Errorf(
+						"expected an absolute path or an http(s) URL with a host as redirect_to "+
+							"of the Route with prefix %s, got: %#v", route.Prefix, route.RedirectTo)
+				}
+			}
+		}
+
+		switch route.RedirectStatus {
+		case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect,
+			http.StatusPermanentRedirect:
+			// Valid redirect status.
+		default:
+			return fmt.Errorf(
+				"expected redirect_status of the Route with prefix %s to be one of 301, 302, 307 or 308, got: %d",
+				route.Prefix, route.RedirectStatus)
+		}
+
+		if route.RedirectStatus != 0 && route.RedirectTo == "" {
+			return fmt.Errorf("redirect_status was specified without redirect_to for the Route with prefix %s",
+				route.Prefix)
+		}
+
+		isDir := false
+		for _, target := range targets {
+			if strings.HasPrefix(target, "/") {
+				isDir = true
+			}
+		}
+
+		if isDir && len(targets) > 1 {
+			return fmt.Errorf("expected a single directory target for the Route with prefix %s, got: %#v",
+				route.Prefix, targets)
+		}
+
+		for _, target := range targets {
+			if strings.HasPrefix(target, "/") {
+				err := checkReadable(target)
+				if err != nil {
+					return fmt.Errorf("the target of the Route with prefix %s can not be served: %s",
+						route.Prefix, err.Error())
+				}
+				continue
+			}
+
+			u, err := url.ParseRequestURI(target)
+			if err != nil {
+				return fmt.Errorf("expected a path or an URL as the target of the Route with prefix %s, got: %#v",
+					route.Prefix, target)
+			}
+
+			if u.Scheme != "http" && u.Scheme != "https" {
+				return fmt.Errorf("expected the scheme http or https in the target of the Route with prefix %s, got: %#v",
+					route.Prefix, target)
+			}
+
+			if u.Host == "" {
+				return fmt.Errorf("expected a host in the target of the Route with prefix %s, got: %#v",
+					route.Prefix, target)
+			}
+		}
+
+		if (route.CacheControl != "" || route.ImmutablePattern != "") && !isDir {
+			return fmt.Errorf(
+				"cache_control and immutable_pattern can only be used with a directory target of the Route with prefix %s",
+				route.Prefix)
+		}
+
+		if route.ImmutablePattern != "" {
+			_, err := regexp.Compile(route.ImmutablePattern)
+			if err != nil {
+				return fmt.Errorf("invalid immutable_pattern of the Route with prefix %s: %#v: %s",
+					route.Prefix, route.ImmutablePattern, err.Error())
+			}
+		}
+
+		if route.SPAFallback && !isDir {
+			return fmt.Errorf("spa_fallback can only be used with a directory target of the Route with prefix %s",
+				route.Prefix)
+		}
+
+		if route.DirectoryListing != nil && !isDir {
+			return fmt.Errorf(
+				"directory_listing can only be used with a directory target of the Route with prefix %s",
+				route.Prefix)
+		}
+
+		if route.IndexFile != "" {
+			if !isDir {
+				return fmt.Errorf("index_file can only be used with a directory target of the Route with prefix %s",
+					route.Prefix)
+			}
+
+			if strings.Contains(route.IndexFile, "/") || route.IndexFile == "." || route.IndexFile == ".." {
+				return fmt.Errorf("expected a plain file name as index_file of the Route with prefix %s, got: %#v",
+					route.Prefix, route.IndexFile)
+			}
+		}
+
+		if route.Match != "" {
+			_, err := regexp.Compile(route.Match)
+			if err != nil {
+				return fmt.Errorf("invalid match of the Route with prefix %s: %#v: %s",
+					route.Prefix, route.Match, err.Error())
+			}
+		}
+
+		if isDir && (len(route.RequestHeaders) > 0 || len(route.ResponseHeaders) > 0) {
+			return fmt.Errorf(
+				"request_headers and response_headers can not be used with the directory target of the Route with prefix %s",
+				route.Prefix)
+		}
+
+		if route.DialTimeoutSeconds < 0 {
+			return fmt.Errorf("expected non-negative dial_timeout_seconds for the Route with prefix %s, got: %d",
+				route.Prefix, route.DialTimeoutSeconds)
+		}
+
+		if route.ResponseHeaderTimeoutSeconds < 0 {
+			return fmt.Errorf(
+				"expected non-negative response_header_timeout_seconds for the Route with prefix %s, got: %d",
+				route.Prefix, route.ResponseHeaderTimeoutSeconds)
+		}
+
+		if isDir && (route.PreserveHost != nil || route.UpstreamHost != "") {
+			return fmt.Errorf(
+				"preserve_host and upstream_host can not be used with the directory target of the Route with prefix %s",
+				route.Prefix)
+		}
+
+		if route.PreserveHost != nil && route.UpstreamHost != "" {
+			return fmt.Errorf(
+				"both preserve_host and upstream_host are set for the Route with prefix %s, but only one is allowed",
+				route.Prefix)
+		}
+
+		if route.LogSampleRate != nil && (*route.LogSampleRate < 0 || *route.LogSampleRate > 1) {
+			return fmt.Errorf("expected log_sample_rate between 0 and 1 for the Route with prefix %s, got: %v",
+				route.Prefix, *route.LogSampleRate)
+		}
+
+		if route.Retries < 0 {
+			return fmt.Errorf("expected non-negative retries for the Route with prefix %s, got: %d",
+				route.Prefix, route.Retries)
+		}
+
+		if isDir && route.Retries > 0 {
+			return fmt.Errorf("retries can not be used with the directory target of the Route with prefix %s",
+				route.Prefix)
+		}
+
+		if route.UpstreamTLS != nil {
+			if isDir {
+				return fmt.Errorf(
+					"upstream_tls can not be used with the directory target of the Route with prefix %s",
+					route.Prefix)
+			}
+
+			if (route.UpstreamTLS.CertPath == "") != (route.UpstreamTLS.KeyPath == "") {
+				return fmt.Errorf(
+					"expected both or none of cert_path and key_path in upstream_tls of the Route with prefix %s",
+					route.Prefix)
+			}
+		}
+
+		if route.Rewrite != nil {
+			if isDir {
+				return fmt.Errorf(
+					"rewrite can not be used with the directory target of the Route with prefix %s",
+					route.Prefix)
+			}
+
+			_, err := regexp.Compile(route.Rewrite.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid rewrite pattern of the Route with prefix %s: %#v: %s",
+					route.Prefix, route.Rewrite.Pattern, err.Error())
+			}
+		}
+
+		if route.ForwardClientCertFields && !route.ForwardClientCert {
+			return fmt.Errorf(
+				"forward_client_cert_fields requires forward_client_cert for the Route with prefix %s",
+				route.Prefix)
+		}
+
+		if route.ForwardClientCert && cfg.ClientCAPath == "" {
+			return fmt.Errorf(
+				"forward_client_cert of the Route with prefix %s requires client_ca_path in cfg "+
+					"since the client certificates are not requested otherwise", route.Prefix)
+		}
+
+		if route.HealthCheck != nil {
+			if route.HealthCheck.MaxFails < 1 {
+				return fmt.Errorf(
+					"expected positive max_fails in the health check of the Route with prefix %s, got: %d",
+					route.Prefix, route.HealthCheck.MaxFails)
+			}
+
+			if route.HealthCheck.FailTimeoutSeconds < 1 {
+				return fmt.Errorf(
+					"expected positive fail_timeout_seconds in the health check of the Route with prefix %s, got: %d",
+					route.Prefix, route.HealthCheck.FailTimeoutSeconds)
+			}
+		}
+
+		if route.ActiveHealth != nil {
+			if isDir {
+				return fmt.Errorf(
+					"active_health can not be used with the directory target of the Route with prefix %s",
+					route.Prefix)
+			}
+
+			if !strings.HasPrefix(route.ActiveHealth.Path, "/") {
+				return fmt.Errorf(
+					"expected the path of the active health to start with a slash for the Route with prefix %s, got: %#v",
+					route.Prefix, route.ActiveHealth.Path)
+			}
+
+			if route.ActiveHealth.IntervalSeconds < 1 {
+				return fmt.Errorf(
+					"expected positive interval_seconds in the active health of the Route with prefix %s, got: %d",
+					route.Prefix, route.ActiveHealth.IntervalSeconds)
+			}
+
+			if route.ActiveHealth.HealthyThreshold < 1 {
+				return fmt.Errorf(
+					"expected positive healthy_threshold in the active health of the Route with prefix %s, got: %d",
+					route.Prefix, route.ActiveHealth.HealthyThreshold)
+			}
+		}
+
+		if route.MaxRequestBodyBytes < 0 {
+			return fmt.Errorf("expected non-negative max_request_body_bytes for the Route with prefix %s, got: %d",
+				route.Prefix, route.MaxRequestBodyBytes)
+		}
+
+		if route.MaxIdleConns < 0 || route.MaxIdleConnsPerHost < 0 || route.IdleConnTimeoutSeconds < 0 {
+			return fmt.Errorf("expected non-negative max_idle_conns, max_idle_conns_per_host and "+
+				"idle_conn_timeout_seconds for the Route with prefix %s, got: %d, %d and %d",
+				route.Prefix, route.MaxIdleConns, route.MaxIdleConnsPerHost, route.IdleConnTimeoutSeconds)
+		}
+
+		if isDir && (route.MaxIdleConns > 0 || route.MaxIdleConnsPerHost > 0 || route.IdleConnTimeoutSeconds > 0) {
+			return fmt.Errorf("max_idle_conns, max_idle_conns_per_host and idle_conn_timeout_seconds can not be "+
+				"used with the directory target of the Route with prefix %s", route.Prefix)
+		}
+
+		for _, method := range route.Methods {
+			if !methodRe.MatchString(method) {
+				return fmt.Errorf("expected an upper-case method in the methods of the Route with prefix %s, got: %#v",
+					route.Prefix, method)
+			}
+		}
 
-	/* hash of the password. Use revproxyhashry to hash it */
-	PasswordHash string `json:"password_hash"`
-}
+		if route.RateLimit != nil {
+			if route.RateLimit.RequestsPerSecond <= 0 {
+				return fmt.Errorf(
+					"expected positive requests_per_second in the rate limit of the Route with prefix %s, got: %v",
+					route.Prefix, route.RateLimit.RequestsPerSecond)
+			}
 
-// Route represents a route of a reverse proxy.
-type Route struct {
-	/* Route prefix */
-	Prefix string `json:"prefix"`
+			if route.RateLimit.Burst < 1 {
+				return fmt.Errorf(
+					"expected positive burst in the rate limit of the Route with prefix %s, got: %d",
+					route.Prefix, route.RateLimit.Burst)
+			}
+		}
 
-	/*
-	path to the target.
-	If a directory, everything beneath it will be served beneath the prefix.
-	If an URL, redirects to that URL after stripping the prefix.
-	*/
-	Target  string   `json:"target"`
-	AuthIDs []string `json:"auths"`
-}
+		if route.Cache != nil {
+			if isDir || route.RedirectTo != "" {
+				return fmt.Errorf("cache can only be used with the URL targets of the Route with prefix %s",
+					route.Prefix)
+			}
 
-// Config represents a parsed config JSON file.
-type Config struct {
-	Auths          map[string]*Auth `json:"auths"`
-	Domain         string           `json:"domain"`
-	Routes         []Route          `json:"routes"`
-	SslKeyPath     string           `json:"ssl_key_path"`
-	SslCertPath    string           `json:"ssl_cert_path"`
-	LetsencryptDir string           `json:"letsencrypt_dir"`
-	HttpAddress    string           `json:"http_address"`
-	HttpsAddress   string           `json:"https_address"`
-}
+			if route.Cache.MaxEntries < 0 {
+				return fmt.Errorf("expected non-negative max_entries in the cache of the Route with prefix %s, got: %d",
+					route.Prefix, route.Cache.MaxEntries)
+			}
+
+			if route.Cache.MaxEntryBytes < 0 {
+				return fmt.Errorf(
+					"expected non-negative max_entry_bytes in the cache of the Route with prefix %s, got: %d",
+					route.Prefix, route.Cache.MaxEntryBytes)
+			}
+
+			if route.Cache.StaleIfErrorSeconds < 0 {
+				return fmt.Errorf(
+					"expected non-negative stale_if_error_seconds in the cache of the Route with prefix %s, got: %d",
+					route.Prefix, route.Cache.StaleIfErrorSeconds)
+			}
+
+			if len(route.AuthIDs) > 0 && !route.Cache.AllowAuthenticated {
+				return fmt.Errorf("the Route with prefix %s requires authentication, so its cache needs to be "+
+					"explicitly enabled with allow_authenticated", route.Prefix)
+			}
+		}
+
+		if route.CircuitBreaker != nil {
+			if isDir || route.RedirectTo != "" {
+				return fmt.Errorf(
+					"circuit_breaker can only be used with the URL targets of the Route with prefix %s",
+					route.Prefix)
+			}
+
+			if route.CircuitBreaker.FailureThreshold <= 0 || route.CircuitBreaker.FailureThreshold > 1 {
+				return fmt.Errorf(
+					"expected failure_threshold in (0, 1] in the circuit breaker of the Route with prefix %s, "+
+						"got: %v", route.Prefix, route.CircuitBreaker.FailureThreshold)
+			}
+
+			if route.CircuitBreaker.OpenSeconds < 1 {
+				return fmt.Errorf(
+					"expected positive open_seconds in the circuit breaker of the Route with prefix %s, got: %d",
+					route.Prefix, route.CircuitBreaker.OpenSeconds)
+			}
+		}
+
+		switch route.EncodedSlashes {
+		case "", EncodedSlashesReject, EncodedSlashesDecode:
+		case EncodedSlashesRaw:
+			if isDir {
+				return fmt.Errorf(
+					"encoded_slashes %#v can not be used with the directory target of the Route with prefix %s",
+					route.EncodedSlashes, route.Prefix)
+			}
+		default:
+			return fmt.Errorf("unknown encoded_slashes of the Route with prefix %s: %#v",
+				route.Prefix, route.EncodedSlashes)
+		}
+
+		if route.Public && len(route.AuthIDs) > 0 {
+			return fmt.Errorf("the Route with prefix %s is public, but lists auths: %#v",
+				route.Prefix, route.AuthIDs)
+		}
 
-// Validate validates the parsed config.
-func Validate(cfg *Config) error {
-	for _, route := range cfg.Routes {
 		for _, authID := range route.AuthIDs {
 			_, ok := cfg.Auths[authID]
 
@@ -56,22 +1186,146 @@ func Validate(cfg *Config) error {
 		}
 	}
 
+	for id, a := range cfg.Auths {
+		switch a.Type {
+		case "", AuthTypeBasic:
+			if len(a.TokenHashes) > 0 {
+				return fmt.Errorf("token_hashes can only be used with the bearer type in the auth %s", id)
+			}
+
+			if strings.HasPrefix(a.PasswordHash, "$argon2id$") {
+				err := checkArgon2idParams(a.PasswordHash)
+				if err != nil {
+					return fmt.Errorf("invalid password_hash in the auth %s: %s", id, err.Error())
+				}
+			}
+
+		case AuthTypeBearer:
+			if len(a.TokenHashes) == 0 {
+				return fmt.Errorf("expected at least one token hash in the bearer auth %s", id)
+			}
+
+		case AuthTypeJWT:
+			if (a.SigningKey == "") == (a.JWKSURL == "") {
+				return fmt.Errorf("expected either signing_key or jwks_url in the jwt auth %s", id)
+			}
+
+			if a.JWKSURL != "" {
+				u, err := url.Parse(a.JWKSURL)
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+					return fmt.Errorf("invalid jwks_url in the jwt auth %s: %#v", id, a.JWKSURL)
+				}
+			}
+
+		default:
+			return fmt.Errorf("unknown type of the auth %s: %#v", id, a.Type)
+		}
+	}
+
 	if (cfg.SslCertPath != "" && cfg.SslKeyPath == "") ||
 		(cfg.SslCertPath == "" && cfg.SslKeyPath != "") {
 		return fmt.Errorf("either both SSL cert and key are empty, or none: %#v and %#v",
 			cfg.SslCertPath, cfg.SslKeyPath)
 	}
 
-	useSSL := (cfg.SslCertPath != "" && cfg.SslKeyPath == "") || cfg.LetsencryptDir != ""
+	useSSL := UsesSSL(cfg)
+
+	for i, c := range cfg.Certificates {
+		if c.CertPath == "" || c.KeyPath == "" {
+			return fmt.Errorf("expected both cert_path and key_path in the certificate %d in cfg", i)
+		}
+
+		if len(c.Hostnames) == 0 {
+			return fmt.Errorf("expected at least one host name in the certificate %d in cfg: %s", i, c.CertPath)
+		}
+	}
+
+	if cfg.HSTS != nil {
+		if !useSSL {
+			return fmt.Errorf("hsts was specified in cfg, but no TLS is configured")
+		}
+
+		if cfg.HSTS.MaxAgeSeconds < 0 {
+			return fmt.Errorf("expected non-negative hsts.max_age_seconds in cfg, got: %d", cfg.HSTS.MaxAgeSeconds)
+		}
+	}
+
+	if cfg.SslKeyPassphrase != "" && cfg.SslKeyPath == "" {
+		return fmt.Errorf("ssl_key_passphrase in cfg requires ssl_key_path")
+	}
+
+	if cfg.OCSPStapling && cfg.SslCertPath == "" {
+		return fmt.Errorf("ocsp_stapling was specified in cfg, but no ssl_cert_path")
+	}
+
+	if cfg.ClientCAPath != "" {
+		if !UsesSSL(cfg) {
+			return fmt.Errorf("client_ca_path was specified in cfg, but no TLS is served")
+		}
+
+		err := checkReadable(cfg.ClientCAPath)
+		if err != nil {
+			return fmt.Errorf("the client_ca_path in cfg can not be read: %s", err.Error())
+		}
+	}
+
+	if UsesLetsencrypt(cfg) && len(cfg.Certificates) > 0 {
+		return fmt.Errorf("both Let's encrypt and certificates were specified in cfg")
+	}
+
+	if UsesLetsencrypt(cfg) && cfg.SslCertPath != "" {
+		return fmt.Errorf("both Let's encrypt and ssl_cert_path were specified in cfg: %#v", cfg.SslCertPath)
+	}
+
+	if UsesLetsencrypt(cfg) && cfg.Domain == "" {
+		return fmt.Errorf("Let's encrypt was specified in cfg, but no domain")
+	}
+
+	if !UsesLetsencrypt(cfg) &&
+		(cfg.LetsencryptStaging || cfg.LetsencryptEmail != "" || len(cfg.LetsencryptHosts) > 0) {
+		return fmt.Errorf("letsencrypt_staging, letsencrypt_email and letsencrypt_hosts in cfg " +
+			"require letsencrypt_dir or letsencrypt_cache")
+	}
+
+	if c := cfg.LetsencryptCache; c != nil {
+		switch c.Type {
+		case "", LetsencryptCacheDir:
+			if cfg.LetsencryptDir == "" {
+				return fmt.Errorf("the letsencrypt_cache of type %#v in cfg requires letsencrypt_dir",
+					LetsencryptCacheDir)
+			}
+
+			if c.RedisURL != "" || c.KeyPrefix != "" {
+				return fmt.Errorf("redis_url and key_prefix of letsencrypt_cache in cfg require the type %#v",
+					LetsencryptCacheRedis)
+			}
+
+		case LetsencryptCacheRedis:
+			if cfg.LetsencryptDir != "" {
+				return fmt.Errorf("both letsencrypt_dir and the letsencrypt_cache of type %#v were specified in cfg",
+					LetsencryptCacheRedis)
+			}
+
+			_, err := ParseRedisURL(c.RedisURL)
+			if err != nil {
+				return fmt.Errorf("invalid redis_url of letsencrypt_cache in cfg: %s", err.Error())
+			}
+
+		default:
+			return fmt.Errorf("unexpected type of letsencrypt_cache in cfg, expected %#v or %#v: %#v",
+				LetsencryptCacheDir, LetsencryptCacheRedis, c.Type)
+		}
+	}
 
-	if cfg.LetsencryptDir != "" && cfg.SslCertPath != "" {
-		return fmt.Errorf("both letsencrypt_dir and ssl_cert_path were specified in cfg: %#v and %#v",
-			cfg.LetsencryptDir, cfg.SslCertPath)
+	if cfg.LetsencryptEmail != "" && !strings.Contains(cfg.LetsencryptEmail, "@") {
+		return fmt.Errorf("invalid letsencrypt_email in cfg: %#v", cfg.LetsencryptEmail)
 	}
 
-	if cfg.LetsencryptDir != "" && cfg.Domain == "" {
-		return fmt.Errorf("letsencrypt_dir was specified in cfg, but no domain: %#v",
-			cfg.LetsencryptDir)
+	for _, host := range cfg.LetsencryptHosts {
+		if host == "" || host == "*." || strings.ContainsAny(strings.TrimPrefix(host, "*."), ":/*") {
+			return fmt.Errorf("invalid host in letsencrypt_hosts in cfg, "+
+				"expected a host name or a wildcard such as \"*.example.com\": %#v", host)
+		}
 	}
 
 	if useSSL && cfg.HttpsAddress == "" {
@@ -82,11 +1336,299 @@ func Validate(cfg *Config) error {
 		return fmt.Errorf("http_address was not specified in cfg")
 	}
 
+	addresses := []struct {
+		name string
+		addr string
+	}{
+		{name: "http_address", addr: cfg.HttpAddress},
+		{name: "https_address", addr: cfg.HttpsAddress},
+	}
+
+	for _, address := range addresses {
+		pth, ok := UnixSocketPath(address.addr)
+		if !ok {
+			continue
+		}
+
+		if !filepath.IsAbs(pth) {
+			return fmt.Errorf("expected an absolute path of the Unix domain socket in %s in cfg, got: %#v",
+				address.name, address.addr)
+		}
+
+		stat, err := os.Stat(filepath.Dir(pth))
+		if err != nil || !stat.IsDir() {
+			return fmt.Errorf("the parent directory of the Unix domain socket in %s in cfg does not exist: %s",
+				address.name, filepath.Dir(pth))
+		}
+
+		// The peers of a Unix domain socket have no IP so that all the clients would share a single rate limit
+		// and a single block unless the client IP is conveyed by the proxy in front.
+		if len(cfg.TrustedProxies) == 0 && !cfg.ProxyProtocol {
+			if cfg.AuthMaxFailures > 0 {
+				return fmt.Errorf("auth_max_failures can not tell the clients apart on the Unix domain socket "+
+					"in %s in cfg; specify trusted_proxies or proxy_protocol", address.name)
+			}
+
+			for _, route := range cfg.Routes {
+				if route.RateLimit != nil {
+					return fmt.Errorf("the rate limit of the Route with prefix %s can not tell the clients apart "+
+						"on the Unix domain socket in %s in cfg; specify trusted_proxies or proxy_protocol",
+						route.Prefix, address.name)
+				}
+			}
+		}
+	}
+
+	timeouts := []struct {
+		name    string
+		seconds int
+	}{
+		{name: "read_timeout_seconds", seconds: cfg.ReadTimeoutSeconds},
+		{name: "read_header_timeout_seconds", seconds: cfg.ReadHeaderTimeoutSeconds},
+		{name: "write_timeout_seconds", seconds: cfg.WriteTimeoutSeconds},
+		{name: "idle_timeout_seconds", seconds: cfg.IdleTimeoutSeconds},
+		{name: "http2_idle_timeout_seconds", seconds: cfg.HTTP2IdleTimeoutSeconds},
+		{name: "shutdown_timeout_seconds", seconds: cfg.ShutdownTimeoutSeconds},
+		{name: "shutdown_delay_seconds", seconds: cfg.ShutdownDelaySeconds},
+	}
+
+	for _, timeout := range timeouts {
+		if timeout.seconds < 0 {
+			return fmt.Errorf("expected non-negative %s in cfg, got: %d", timeout.name, timeout.seconds)
+		}
+	}
+
+	if cfg.HTTP2IdleTimeoutSeconds != 0 && (!useSSL || (cfg.HTTP2 != nil && !*cfg.HTTP2)) {
+		return fmt.Errorf("http2_idle_timeout_seconds in cfg requires the HTTPS server with HTTP/2")
+	}
+
+	for _, cidr := range cfg.TrustedProxies {
+		_, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR in trusted_proxies in cfg: %#v", cidr)
+		}
+	}
+
+	authLimits := []struct {
+		name  string
+		value int
+	}{
+		{name: "auth_max_failures", value: cfg.AuthMaxFailures},
+		{name: "auth_failure_window_seconds", value: cfg.AuthFailureWindowSeconds},
+		{name: "auth_cooldown_seconds", value: cfg.AuthCooldownSeconds},
+	}
+
+	for _, limit := range authLimits {
+		if limit.value < 0 {
+			return fmt.Errorf("expected non-negative %s in cfg, got: %d", limit.name, limit.value)
+		}
+	}
+
+	if cfg.MaxConcurrentRequests < 0 {
+		return fmt.Errorf("expected non-negative max_concurrent_requests in cfg, got: %d",
+			cfg.MaxConcurrentRequests)
+	}
+
+	if cfg.MaxRequestBodyBytes < 0 {
+		return fmt.Errorf("expected non-negative max_request_body_bytes in cfg, got: %d", cfg.MaxRequestBodyBytes)
+	}
+
+	if cfg.MaxHeaderBytes < 0 {
+		return fmt.Errorf("expected non-negative max_header_bytes in cfg, got: %d", cfg.MaxHeaderBytes)
+	}
+
+	if cfg.MaxIdleConns < 0 || cfg.MaxIdleConnsPerHost < 0 || cfg.IdleConnTimeoutSeconds < 0 {
+		return fmt.Errorf("expected non-negative max_idle_conns, max_idle_conns_per_host and "+
+			"idle_conn_timeout_seconds in cfg, got: %d, %d and %d",
+			cfg.MaxIdleConns, cfg.MaxIdleConnsPerHost, cfg.IdleConnTimeoutSeconds)
+	}
+
+	if cfg.DefaultTarget != "" {
+		if prefixes["/"] {
+			return fmt.Errorf("default_target in cfg can not be combined with a Route with the prefix /")
+		}
+
+		if strings.HasPrefix(cfg.DefaultTarget, "/") {
+			err := checkReadable(cfg.DefaultTarget)
+			if err != nil {
+				return fmt.Errorf("default_target in cfg can not be served: %s", err.Error())
+			}
+		} else {
+			u, err := url.ParseRequestURI(cfg.DefaultTarget)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("expected a path or an http(s) URL with a host as default_target in cfg, got: %#v",
+					cfg.DefaultTarget)
+			}
+		}
+	}
+
+	for key, pth := range cfg.ErrorPages {
+		code, err := strconv.Atoi(key)
+		if err != nil || code < 400 || code > 599 {
+			return fmt.Errorf("expected a status code between 400 and 599 as a key of error_pages in cfg, got: %#v",
+				key)
+		}
+
+		if pth == "" {
+			return fmt.Errorf("unexpected empty path of the error page for the status code %d in cfg", code)
+		}
+	}
+
+	switch cfg.RedirectStatus {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect,
+		http.StatusPermanentRedirect:
+		// Valid redirect status.
+	default:
+		return fmt.Errorf("expected redirect_status in cfg to be one of 301, 302, 307 or 308, got: %d",
+			cfg.RedirectStatus)
+	}
+
+	switch cfg.LogTarget {
+	case "":
+		if cfg.SyslogFacility != "" || cfg.SyslogTag != "" {
+			return fmt.Errorf("syslog_facility and syslog_tag in cfg require log_target %#v", LogTargetSyslog)
+		}
+
+	case LogTargetSyslog:
+		if runtime.GOOS == "windows" {
+			return fmt.Errorf("log_target %#v in cfg is not supported on Windows", LogTargetSyslog)
+		}
+
+		if cfg.AccessLogPath != "" || cfg.ErrorLogPath != "" {
+			return fmt.Errorf("log_target %#v in cfg can not be combined with access_log_path or error_log_path",
+				LogTargetSyslog)
+		}
+
+		if _, ok := SyslogFacilities[cfg.SyslogFacility]; cfg.SyslogFacility != "" && !ok {
+			return fmt.Errorf("unknown syslog_facility in cfg: %#v", cfg.SyslogFacility)
+		}
+
+	default:
+		return fmt.Errorf("expected log_target in cfg to be empty or %#v, got: %#v", LogTargetSyslog, cfg.LogTarget)
+	}
+
+	switch cfg.LogFormat {
+	case "", LogFormatJSON, LogFormatPlain, LogFormatCombined:
+		// Valid log format.
+	default:
+		return fmt.Errorf("expected log_format in cfg to be one of %#v, %#v or %#v, got: %#v",
+			LogFormatJSON, LogFormatPlain, LogFormatCombined, cfg.LogFormat)
+	}
+
+	if cfg.LogMaxSizeMB < 0 {
+		return fmt.Errorf("expected non-negative log_max_size_mb in cfg, got: %d", cfg.LogMaxSizeMB)
+	}
+
 	return nil
 }
 
-// Load loads and parses the config file from the given path.
-func Load(path string) (cfg *Config, err error) {
+// checkArgon2idParams checks that the memory and the time of the Argon2id hash in the PHC string format
+// (e.g., "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>") are within the upper bounds.
+func checkArgon2idParams(passwordHash string) error {
+	parts := strings.Split(passwordHash, "$")
+	if len(parts) != 6 {
+		return fmt.Errorf("expected 5 fields separated by $ in the Argon2id hash, got %d", len(parts)-1)
+	}
+
+	var memory, iterations uint64
+	var threads uint8
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads)
+	if err != nil {
+		return fmt.Errorf("failed to parse the parameters of the Argon2id hash: %s", parts[3])
+	}
+
+	if memory > MaxArgon2idMemoryKiB {
+		return fmt.Errorf("expected the memory of the Argon2id hash to be at most %d KiB, got: %d",
+			MaxArgon2idMemoryKiB, memory)
+	}
+
+	if iterations > MaxArgon2idTime {
+		return fmt.Errorf("expected the time of the Argon2id hash to be at most %d, got: %d",
+			MaxArgon2idTime, iterations)
+	}
+
+	return nil
+}
+
+// Warnings lists the settings of the parsed config which are valid, but potentially unsafe.
+func Warnings(cfg *Config) []string {
+	var warnings []string
+
+	if cfg.DisableHttpsRedirect && UsesSSL(cfg) {
+		warnings = append(warnings, fmt.Sprintf(
+			"disable_https_redirect is set: the content is served over plaintext HTTP on %s as well",
+			cfg.HttpAddress))
+	}
+
+	if cfg.HSTS != nil && cfg.HSTS.Preload && (!cfg.HSTS.IncludeSubdomains || cfg.HSTS.MaxAgeSeconds < 31536000) {
+		warnings = append(warnings,
+			"hsts.preload is set, but the preload lists require include_subdomains and "+
+				"max_age_seconds of at least 31536000 (one year)")
+	}
+
+	if c := cfg.LetsencryptCache; c != nil && c.Type == LetsencryptCacheRedis {
+		if u, err := ParseRedisURL(c.RedisURL); err == nil && !u.UseTLS {
+			warnings = append(warnings, fmt.Sprintf(
+				"the letsencrypt_cache sends the private keys in cleartext to the Redis server at %s: "+
+					"use the scheme rediss unless the server is reachable only over a trusted network", u.Addr))
+		}
+	}
+
+	if cfg.LogFormat == LogFormatPlain || cfg.LogFormat == LogFormatCombined {
+		for _, route := range cfg.Routes {
+			if route.LogHeaders {
+				warnings = append(warnings, fmt.Sprintf(
+					"the Route with prefix %s sets log_headers, but the headers are only logged "+
+						"with the log_format %#v, not %#v", route.Prefix, LogFormatJSON, cfg.LogFormat))
+			}
+		}
+	}
+
+	if cfg.HealthAddress != "" && cfg.ShutdownDelaySeconds == 0 {
+		warnings = append(warnings,
+			"health_address is set, but shutdown_delay_seconds is 0: the servers stop accepting new connections "+
+				"as soon as the health probes fail, before the load balancers can notice it")
+	}
+
+	if cfg.WriteTimeoutSeconds == 0 {
+		for _, route := range cfg.Routes {
+			targets := route.AllTargets()
+			if len(targets) > 0 && !strings.HasPrefix(targets[0], "/") && !route.DisableWriteTimeout {
+				warnings = append(warnings, fmt.Sprintf(
+					"the Route with prefix %s proxies to URL targets, but does not set disable_write_timeout: "+
+						"its responses are cut after the default write timeout of 300 seconds", route.Prefix))
+			}
+		}
+	}
+
+	for _, route := range cfg.Routes {
+		if route.UpstreamTLS != nil && route.UpstreamTLS.InsecureSkipVerify {
+			warnings = append(warnings, fmt.Sprintf(
+				"the Route with prefix %s does not verify the certificates of its upstreams "+
+					"(upstream_tls.insecure_skip_verify is set)", route.Prefix))
+		}
+	}
+
+	for _, route := range cfg.Routes {
+		for _, authID := range route.AuthIDs {
+			a := cfg.Auths[authID]
+
+			if (a.Type == "" || a.Type == AuthTypeBasic) && a.Username == "" {
+				warnings = append(warnings, fmt.Sprintf(
+					"the Route with prefix %s grants access to everybody through the auth %s with an empty "+
+						"username: set public to true instead", route.Prefix, authID))
+				break
+			}
+		}
+	}
+
+	return warnings
+}
+
+// decodeFile reads the config file and decodes it into the generic values with the environment variables expanded.
+//
+// The files with the extension .yaml or .yml are parsed as YAML and all the others as JSON.
+func decodeFile(path string) (raw interface{}, err error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return
@@ -98,12 +1640,66 @@ func Load(path string) (cfg *Config, err error) {
 		return
 	}
 
-	cfg = &Config{}
+	// The environment variables are expanded in the string values before the config is parsed.
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		raw, err = decodeYAML(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the YAML: %s", err.Error())
+		}
+
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		decoder.UseNumber()
+		err = decoder.Decode(&raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	raw, err = expandEnvValues(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand the environment variables: %s", err.Error())
+	}
+
+	return
+}
+
+// unmarshal parses the decoded generic values into the config.
+func unmarshal(raw interface{}) (*Config, error) {
+	text, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
 	err = json.Unmarshal(text, cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	return cfg, nil
+}
+
+// Load loads and parses the config file from the given path and merges the included files.
+//
+// The files with the extension .yaml or .yml are parsed as YAML and all the others as JSON.
+func Load(path string) (cfg *Config, err error) {
+	raw, err := decodeFile(path)
+	if err != nil {
+		return
+	}
+
+	cfg, err = unmarshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	err = include(cfg, path)
+	if err != nil {
+		return nil, err
+	}
+
 	err = Validate(cfg)
 	if err != nil {
 		return
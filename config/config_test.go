@@ -0,0 +1,83 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestValidateDuplicatePrefixes(t *testing.T) {
+	tests := []struct {
+		name        string
+		routes      []Route
+		expectedErr string
+	}{
+		{name: "distinct prefixes",
+			routes: []Route{
+				{Prefix: "/", Target: "http://127.0.0.1:8080", Public: true},
+				{Prefix: "/api/", Target: "http://127.0.0.1:8081", Public: true}}},
+		{name: "duplicate prefix",
+			routes: []Route{
+				{Prefix: "/api/", Target: "http://127.0.0.1:8080", Public: true},
+				{Prefix: "/api/", Target: "http://127.0.0.1:8081", Public: true}},
+			expectedErr: "multiple Routes share the prefix /api/"},
+		{name: "duplicate prefix of the regular expression routes",
+			routes: []Route{
+				{Prefix: "/api/", Match: "^/api/v1/", Target: "http://127.0.0.1:8080", Public: true},
+				{Prefix: "/api/", Match: "^/api/v2/", Target: "http://127.0.0.1:8081", Public: true}}},
+	}
+
+	for _, test := range tests {
+		err := Validate(&Config{HttpAddress: "127.0.0.1:8000", Routes: test.routes, Auths: map[string]*Auth{}})
+
+		switch {
+		case test.expectedErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+
+		case test.expectedErr != "" && err == nil:
+			t.Errorf("%s: expected the error %#v, got none", test.name, test.expectedErr)
+
+		case test.expectedErr != "" && err.Error() != test.expectedErr:
+			t.Errorf("%s: expected the error %#v, got %#v", test.name, test.expectedErr, err.Error())
+		}
+	}
+}
+
+func TestValidateArgon2idParams(t *testing.T) {
+	tests := []struct {
+		name         string
+		passwordHash string
+		expectedErr  string
+	}{
+		{name: "within the bounds",
+			passwordHash: "$argon2id$v=19$m=65536,t=3,p=4$c29tZS1zYWx0$c29tZS1rZXk"},
+		{name: "at the bounds",
+			passwordHash: "$argon2id$v=19$m=1048576,t=64,p=4$c29tZS1zYWx0$c29tZS1rZXk"},
+		{name: "memory beyond the bound",
+			passwordHash: "$argon2id$v=19$m=1048577,t=3,p=4$c29tZS1zYWx0$c29tZS1rZXk",
+			expectedErr: "invalid password_hash in the auth some-auth: " +
+				"expected the memory of the Argon2id hash to be at most 1048576 KiB, got: 1048577"},
+		{name: "time beyond the bound",
+			passwordHash: "$argon2id$v=19$m=65536,t=65,p=4$c29tZS1zYWx0$c29tZS1rZXk",
+			expectedErr: "invalid password_hash in the auth some-auth: " +
+				"expected the time of the Argon2id hash to be at most 64, got: 65"},
+	}
+
+	for _, test := range tests {
+		cfg := &Config{
+			HttpAddress: "127.0.0.1:8000",
+			Routes:      []Route{{Prefix: "/", Target: "http://127.0.0.1:8080", AuthIDs: []string{"some-auth"}}},
+			Auths:       map[string]*Auth{"some-auth": {Username: "some-user", PasswordHash: test.passwordHash}}}
+
+		err := Validate(cfg)
+
+		switch {
+		case test.expectedErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+
+		case test.expectedErr != "" && err == nil:
+			t.Errorf("%s: expected the error %#v, got none", test.name, test.expectedErr)
+
+		case test.expectedErr != "" && err.Error() != test.expectedErr:
+			t.Errorf("%s: expected the error %#v, got %#v", test.name, test.expectedErr, err.Error())
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// expandEnv replaces the references ${VAR} and ${VAR:-default} in the string by the environment variables.
+//
+// "$$" stands for a literal "$". A "$" which is followed by neither "{" nor "$" is kept as-is so that the
+// password hashes (e.g., "$2a$10$...") need not be escaped. A variable which is referenced without a default
+// needs to be set.
+func expandEnv(s string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+
+		case '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end == -1 {
+				return "", fmt.Errorf("unterminated reference to an environment variable: %#v", s[i:])
+			}
+
+			ref := s[i+2 : i+2+end]
+
+			name, defaultValue, hasDefault := ref, "", false
+			if j := strings.Index(ref, ":-"); j != -1 {
+				name, defaultValue, hasDefault = ref[:j], ref[j+2:], true
+			}
+
+			if name == "" {
+				return "", fmt.Errorf("empty name of an environment variable in: %#v", s)
+			}
+
+			value, isSet := os.LookupEnv(name)
+			switch {
+			case hasDefault && value == "":
+				// As in the shell, the default also replaces an empty value.
+				value = defaultValue
+
+			case !isSet:
+				return "", fmt.Errorf("the environment variable %s is referenced, but not set", name)
+			}
+
+			b.WriteString(value)
+			i += 2 + end
+
+		default:
+			b.WriteByte('$')
+		}
+	}
+
+	return b.String(), nil
+}
+
+// verbatimKeys lists the keys of the regular expressions and their replacements. Their values are never expanded
+// since "${1}" and "$$" have their own meaning in the Go regular expressions.
+var verbatimKeys = map[string]bool{
+	"match":             true,
+	"immutable_pattern": true,
+	"pattern":           true,
+	"replacement":       true,
+}
+
+// expandEnvValues expands the environment variables in all the string values of the decoded JSON except for
+// the values of verbatimKeys.
+func expandEnvValues(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case string:
+		return expandEnv(value)
+
+	case map[string]interface{}:
+		for key, item := range value {
+			if _, ok := item.(string); ok && verbatimKeys[key] {
+				continue
+			}
+
+			expanded, err := expandEnvValues(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err.Error())
+			}
+			value[key] = expanded
+		}
+		return value, nil
+
+	case []interface{}:
+		for i, item := range value {
+			expanded, err := expandEnvValues(item)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %s", i, err.Error())
+			}
+			value[i] = expanded
+		}
+		return value, nil
+
+	default:
+		return v, nil
+	}
+}
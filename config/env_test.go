@@ -0,0 +1,87 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("REVPROXYRY_TEST_DIR", "/secrets")
+	os.Setenv("REVPROXYRY_TEST_EMPTY", "")
+	defer os.Unsetenv("REVPROXYRY_TEST_DIR")
+	defer os.Unsetenv("REVPROXYRY_TEST_EMPTY")
+
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{text: "${REVPROXYRY_TEST_DIR}/key.pem", expected: "/secrets/key.pem"},
+		{text: "${REVPROXYRY_TEST_EMPTY:-fallback}", expected: "fallback"},
+		{text: "${REVPROXYRY_TEST_UNSET:-fallback}", expected: "fallback"},
+		{text: "price in $$", expected: "price in $"},
+		{text: "$2a$10$abc", expected: "$2a$10$abc"},
+	}
+
+	for _, test := range tests {
+		got, err := expandEnv(test.text)
+		if err != nil {
+			t.Errorf("unexpected error expanding %#v: %s", test.text, err.Error())
+			continue
+		}
+
+		if got != test.expected {
+			t.Errorf("expected %#v expanded to %#v, got %#v", test.text, test.expected, got)
+		}
+	}
+
+	_, err := expandEnv("${REVPROXYRY_TEST_UNSET}")
+	if err == nil {
+		t.Errorf("expected an error on an unset variable without a default")
+	}
+}
+
+func TestExpandEnvValuesSkipsRegexps(t *testing.T) {
+	os.Setenv("REVPROXYRY_TEST_HOST", "upstream.example.com")
+	defer os.Unsetenv("REVPROXYRY_TEST_HOST")
+
+	text := `{
+  "routes": [
+    {
+      "prefix": "/",
+      "target": "http://${REVPROXYRY_TEST_HOST}",
+      "match": "^/(?P<name>[a-z]+)$$",
+      "immutable_pattern": "\\.[0-9a-f]{8}\\.js$$",
+      "rewrite": {"pattern": "^/old/(.*)$$", "replacement": "/new/${1}/${name}"}
+    }
+  ]
+}`
+
+	var raw interface{}
+	err := json.Unmarshal([]byte(text), &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expanded, err := expandEnvValues(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	route := expanded.(map[string]interface{})["routes"].([]interface{})[0].(map[string]interface{})
+
+	expected := map[string]interface{}{
+		"prefix":            "/",
+		"target":            "http://upstream.example.com",
+		"match":             "^/(?P<name>[a-z]+)$$",
+		"immutable_pattern": `\.[0-9a-f]{8}\.js$$`,
+		"rewrite": map[string]interface{}{
+			"pattern":     "^/old/(.*)$$",
+			"replacement": "/new/${1}/${name}"},
+	}
+
+	if !reflect.DeepEqual(route, expected) {
+		t.Errorf("expected the route %#v, got %#v", expected, route)
+	}
+}
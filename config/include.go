@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadIncluded loads the routes and the auths from an included file.
+func loadIncluded(path string) (*Config, error) {
+	raw, err := decodeFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	object, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object with routes and auths")
+	}
+
+	for key := range object {
+		if key != "routes" && key != "auths" {
+			return nil, fmt.Errorf("unexpected property %#v; only routes and auths can be included", key)
+		}
+	}
+
+	return unmarshal(raw)
+}
+
+// include merges the routes and the auths of the files matched by the include patterns of the config.
+//
+// The files are merged in the order of the patterns and, within a pattern, in the lexical order. An auth ID
+// or a prefix of a route without a match may be defined only once across all the files.
+//
+// The main config file is skipped if a pattern with wildcards matches it (e.g., "*.json" next to it), while
+// a pattern naming it explicitly is an error.
+func include(cfg *Config, path string) error {
+	if len(cfg.Include) == 0 {
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+
+	mainInfo, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	// origins map the auth IDs and the prefixes to the file defining them.
+	authOrigins := make(map[string]string)
+	for id := range cfg.Auths {
+		authOrigins[id] = path
+	}
+
+	prefixOrigins := make(map[string]string)
+	for _, route := range cfg.Routes {
+		if route.Match == "" {
+			prefixOrigins[route.Prefix] = path
+		}
+	}
+
+	included := make(map[string]bool)
+
+	for _, pattern := range cfg.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid include pattern %#v: %s", pattern, err.Error())
+		}
+
+		if len(matches) == 0 && !strings.ContainsAny(pattern, `*?[\`) {
+			return fmt.Errorf("the included file does not exist: %s", pattern)
+		}
+
+		for _, match := range matches {
+			if included[match] {
+				continue
+			}
+			included[match] = true
+
+			fi, err := os.Stat(match)
+			if err != nil {
+				return err
+			}
+
+			if fi.IsDir() {
+				continue
+			}
+
+			if os.SameFile(fi, mainInfo) {
+				if !strings.ContainsAny(pattern, `*?[\`) {
+					return fmt.Errorf("the main config file can not include itself: %s", match)
+				}
+
+				continue
+			}
+
+			other, err := loadIncluded(match)
+			if err != nil {
+				return fmt.Errorf("failed to load the included file %s: %s", match, err.Error())
+			}
+
+			for id, a := range other.Auths {
+				if origin, ok := authOrigins[id]; ok {
+					return fmt.Errorf("the auth %s of the included file %s is already defined in %s",
+						id, match, origin)
+				}
+				authOrigins[id] = match
+
+				if cfg.Auths == nil {
+					cfg.Auths = make(map[string]*Auth)
+				}
+				cfg.Auths[id] = a
+			}
+
+			for _, route := range other.Routes {
+				if route.Match == "" {
+					if origin, ok := prefixOrigins[route.Prefix]; ok {
+						return fmt.Errorf("the prefix %s of a Route in the included file %s is already defined in %s",
+							route.Prefix, match, origin)
+					}
+					prefixOrigins[route.Prefix] = match
+				}
+
+				cfg.Routes = append(cfg.Routes, route)
+			}
+		}
+	}
+
+	return nil
+}
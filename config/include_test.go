@@ -0,0 +1,63 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIncludeMainFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "service.json"),
+		[]byte(`{"routes": [{"prefix": "/service/", "target": "http://127.0.0.1:8081", "public": true}]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		include        string
+		expectedRoutes int
+		expectedErr    string
+	}{
+		{name: "wildcard matching the main file", include: "*.json", expectedRoutes: 2},
+		{name: "main file named explicitly", include: "main.json",
+			expectedErr: "the main config file can not include itself"},
+	}
+
+	for _, test := range tests {
+		pth := filepath.Join(dir, "main.json")
+		err = ioutil.WriteFile(pth, []byte(`{
+  "http_address": "127.0.0.1:8000",
+  "include": ["`+test.include+`"],
+  "routes": [{"prefix": "/", "target": "http://127.0.0.1:8080", "public": true}],
+  "auths": {}
+}`), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := Load(pth)
+
+		switch {
+		case test.expectedErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+
+		case test.expectedErr == "" && len(cfg.Routes) != test.expectedRoutes:
+			t.Errorf("%s: expected %d route(s), got %d", test.name, test.expectedRoutes, len(cfg.Routes))
+
+		case test.expectedErr != "" && err == nil:
+			t.Errorf("%s: expected the error %#v, got none", test.name, test.expectedErr)
+
+		case test.expectedErr != "" && !strings.HasPrefix(err.Error(), test.expectedErr):
+			t.Errorf("%s: expected the error %#v, got %#v", test.name, test.expectedErr, err.Error())
+		}
+	}
+}
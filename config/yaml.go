@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// decodeYAML decodes the YAML text into the same generic values as the JSON decoder produces so that
+// the config can be parsed with the json tags of Config.
+func decodeYAML(text []byte) (interface{}, error) {
+	var raw interface{}
+	err := yaml.Unmarshal(text, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonifyYAML(raw)
+}
+
+// jsonifyYAML converts the YAML mappings, which are decoded with arbitrary keys, into the JSON objects.
+func jsonifyYAML(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			keyStr, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string key, but got: %#v", key)
+			}
+
+			converted, err := jsonifyYAML(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", keyStr, err.Error())
+			}
+			result[keyStr] = converted
+		}
+		return result, nil
+
+	case []interface{}:
+		for i, item := range value {
+			converted, err := jsonifyYAML(item)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %s", i, err.Error())
+			}
+			value[i] = converted
+		}
+		return value, nil
+
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,183 @@
+package forwardproxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPort is the only allowed destination port if none are configured.
+const DefaultPort = 443
+
+// halfCloseTimeout limits how long the tunnel stays open in one direction after the other direction was closed.
+const halfCloseTimeout = 30 * time.Second
+
+// Handler tunnels the CONNECT requests to the allowed destinations as a forward proxy.
+//
+// The destinations not on the allowlist are rejected with 403 Forbidden. The other methods are rejected with
+// 405 Method Not Allowed.
+type Handler struct {
+	// hosts contains the allowed lower-case host names.
+	hosts map[string]bool
+
+	// wildcards contains the suffixes of the allowed wildcards, e.g., ".example.com" of "*.example.com".
+	wildcards map[string]bool
+
+	ports map[string]bool
+
+	dialer *net.Dialer
+	logErr *log.Logger
+}
+
+// New creates the forward proxy to the allowed hosts and ports. A wildcard such as "*.example.com" covers
+// a single label. If no ports are given, only DefaultPort is allowed.
+func New(allowedHosts []string, allowedPorts []int, dialTimeout time.Duration, logErr *log.Logger) *Handler {
+	h := &Handler{
+		hosts:     make(map[string]bool),
+		wildcards: make(map[string]bool),
+		ports:     make(map[string]bool),
+		dialer:    &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second},
+		logErr:    logErr}
+
+	for _, host := range allowedHosts {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if strings.HasPrefix(host, "*.") {
+			h.wildcards[host[1:]] = true
+		} else {
+			h.hosts[host] = true
+		}
+	}
+
+	if len(allowedPorts) == 0 {
+		allowedPorts = []int{DefaultPort}
+	}
+
+	for _, port := range allowedPorts {
+		h.ports[strconv.Itoa(port)] = true
+	}
+
+	return h
+}
+
+// Allowed checks whether the destination is on the allowlist.
+func (h *Handler) Allowed(host string, port string) bool {
+	if !h.ports[port] {
+		return false
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	if h.hosts[name] {
+		return true
+	}
+
+	i := strings.Index(name, ".")
+	return i > 0 && h.wildcards[name[i:]]
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodConnect {
+		w.Header().Set("Allow", http.MethodConnect)
+		http.Error(w, "Only CONNECT is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The hijacking of the connection is specific to HTTP/1.
+	if req.ProtoMajor != 1 {
+		http.Error(w, "CONNECT is only supported over HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		return
+	}
+
+	host, port, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		http.Error(w, "Expected the destination as host:port", http.StatusBadRequest)
+		return
+	}
+
+	if !h.Allowed(host, port) {
+		http.Error(w, "The destination is not allowed", http.StatusForbidden)
+		return
+	}
+
+	upstream, err := h.dialer.DialContext(req.Context(), "tcp", req.Host)
+	if err != nil {
+		h.logErr.Printf("Failed to connect to %s: %s\n", req.Host, err.Error())
+		http.Error(w, "Bad gateway", http.StatusBadGateway)
+		return
+	}
+
+	// The connection is hijacked before responding since net/http would announce a chunked body otherwise.
+	client, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		upstream.Close()
+		h.logErr.Printf("Failed to hijack the connection to tunnel to %s: %s\n", req.Host, err.Error())
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The deadlines of the server do not apply to the tunnel.
+	client.SetDeadline(time.Time{})
+
+	_, err = buffered.WriteString("HTTP/1.1 200 Connection established\r\n\r\n")
+	if err == nil {
+		err = buffered.Flush()
+	}
+
+	if err != nil {
+		client.Close()
+		upstream.Close()
+		h.logErr.Printf("Failed to respond to the CONNECT request to %s: %s\n", req.Host, err.Error())
+		return
+	}
+
+	tunnel(client, buffered, upstream)
+}
+
+// closeWriter is implemented by the connections which can be half-closed, e.g., *net.TCPConn and *tls.Conn.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// closeWrite signals the end of the stream to the peer while the connection can still be read.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(closeWriter); ok {
+		cw.CloseWrite()
+		return
+	}
+
+	conn.Close()
+}
+
+// tunnel copies the bytes in both directions until both are closed. The client's bytes which have already been
+// buffered by the server are read from the reader first.
+//
+// Once a direction is closed, the other one is given halfCloseTimeout to finish.
+func tunnel(client net.Conn, clientReader io.Reader, upstream net.Conn) {
+	defer client.Close()
+	defer upstream.Close()
+
+	done := make(chan struct{}, 2)
+
+	go func() {
+		io.Copy(upstream, clientReader)
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		io.Copy(client, upstream)
+		closeWrite(client)
+		done <- struct{}{}
+	}()
+
+	<-done
+
+	deadline := time.Now().Add(halfCloseTimeout)
+	client.SetDeadline(deadline)
+	upstream.SetDeadline(deadline)
+
+	<-done
+}
@@ -0,0 +1,213 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Intervals of fetching the key set
+const (
+	// jwksRefreshInterval is the age after which the cached key set is fetched again.
+	jwksRefreshInterval = time.Hour
+
+	// jwksMinRefetchInterval limits how often an unknown key ID triggers a fetch so that
+	// the tokens with bogus key IDs can not flood the identity provider.
+	jwksMinRefetchInterval = time.Minute
+)
+
+// keySet caches the public keys fetched from a JWKS URL.
+type keySet struct {
+	url    string
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]webKey
+	fetched     time.Time
+	lastAttempt time.Time
+
+	// fetching is closed once the fetch in flight completes; nil if there is none.
+	fetching chan struct{}
+
+	// fetchErr is the error of the last fetch, if it failed.
+	fetchErr error
+}
+
+// webKey is a public key of the key set together with the algorithm it is restricted to, if any.
+type webKey struct {
+	key crypto.PublicKey
+	alg string
+}
+
+func newKeySet(url string) *keySet {
+	return &keySet{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+
+	// RSA parameters
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// ECDSA parameters
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parse converts the JSON web key to a public key.
+func (jwk *jsonWebKey) parse() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeSegment(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %s", err.Error())
+		}
+
+		e, err := decodeSegment(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %s", err.Error())
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("exponent too large")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %#v", jwk.Crv)
+		}
+
+		x, err := decodeSegment(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %s", err.Error())
+		}
+
+		y, err := decodeSegment(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %s", err.Error())
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type: %#v", jwk.Kty)
+	}
+}
+
+// fetch retrieves and parses the key set. The keys which can not be parsed are skipped.
+func (ks *keySet) fetch() (map[string]webKey, error) {
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the key set: %s", err.Error())
+	}
+
+	keys := make(map[string]webKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.parse()
+		if err != nil {
+			continue
+		}
+
+		keys[jwk.Kid] = webKey{key: key, alg: jwk.Alg}
+	}
+
+	return keys, nil
+}
+
+// key returns the public key with the given ID, fetching the key set if it is stale or does not contain the key.
+//
+// The key set is fetched without holding the lock so that a slow identity provider does not stall the tokens
+// signed with the known keys. Only the callers which need a key missing from the set wait for the fetch in flight.
+func (ks *keySet) key(kid string, now time.Time) (webKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	_, known := ks.keys[kid]
+
+	stale := now.Sub(ks.fetched) > jwksRefreshInterval
+	switch {
+	case (stale || !known) && ks.fetching == nil && now.Sub(ks.lastAttempt) > jwksMinRefetchInterval:
+		ks.lastAttempt = now
+		fetching := make(chan struct{})
+		ks.fetching = fetching
+
+		ks.mu.Unlock()
+		keys, err := ks.fetch()
+		ks.mu.Lock()
+
+		ks.fetching = nil
+		close(fetching)
+
+		ks.fetchErr = err
+		if err == nil {
+			ks.keys = keys
+			ks.fetched = now
+		}
+		// Otherwise keep using the stale keys until the identity provider is reachable again.
+
+	case !known && ks.fetching != nil:
+		fetching := ks.fetching
+
+		ks.mu.Unlock()
+		<-fetching
+		ks.mu.Lock()
+	}
+
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, nil
+		}
+	}
+
+	if ks.keys == nil && ks.fetchErr != nil {
+		return webKey{}, fmt.Errorf("failed to fetch the key set from %s: %s", ks.url, ks.fetchErr.Error())
+	}
+
+	return webKey{}, fmt.Errorf("unknown key ID: %#v", kid)
+}
@@ -0,0 +1,340 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/Parquery/revproxyry/config"
+)
+
+// Leeway is the tolerated clock skew when checking the expiration and the not-before time.
+const Leeway = 60 * time.Second
+
+// algorithm describes a supported signing algorithm.
+type algorithm struct {
+	hash crypto.Hash
+	kind string // "HS", "RS" or "ES"
+
+	// curve is the name of the curve which the ECDSA key must use. Only set if the kind is "ES".
+	curve string
+}
+
+var algorithms = map[string]algorithm{
+	"HS256": {hash: crypto.SHA256, kind: "HS"},
+	"HS384": {hash: crypto.SHA384, kind: "HS"},
+	"HS512": {hash: crypto.SHA512, kind: "HS"},
+	"RS256": {hash: crypto.SHA256, kind: "RS"},
+	"RS384": {hash: crypto.SHA384, kind: "RS"},
+	"RS512": {hash: crypto.SHA512, kind: "RS"},
+	"ES256": {hash: crypto.SHA256, kind: "ES", curve: "P-256"},
+	"ES384": {hash: crypto.SHA384, kind: "ES", curve: "P-384"},
+	"ES512": {hash: crypto.SHA512, kind: "ES", curve: "P-521"},
+}
+
+// Verifier verifies the signature and the claims of JSON Web Tokens.
+type Verifier struct {
+	// secret is the shared HMAC secret. Only set if the signing key is not a PEM-encoded public key.
+	secret []byte
+
+	// publicKey is the parsed PEM-encoded public key, if given as the signing key.
+	publicKey crypto.PublicKey
+
+	// jwks is the key set fetched from the JWKS URL, if given.
+	jwks *keySet
+
+	issuer   string
+	audience string
+
+	// allowMissingExp accepts the tokens without the expiration claim.
+	allowMissingExp bool
+
+	// ForwardClaims maps the claims to the headers under which they are forwarded to the upstream.
+	ForwardClaims map[string]string
+}
+
+// New creates a verifier based on the JWT authentication specified in the config.
+func New(cfgAuth *config.Auth) (*Verifier, error) {
+	v := &Verifier{
+		issuer:          cfgAuth.Issuer,
+		audience:        cfgAuth.Audience,
+		allowMissingExp: cfgAuth.AllowMissingExp,
+		ForwardClaims:   cfgAuth.ForwardClaims}
+
+	switch {
+	case cfgAuth.JWKSURL != "":
+		v.jwks = newKeySet(cfgAuth.JWKSURL)
+
+	case strings.HasPrefix(strings.TrimSpace(cfgAuth.SigningKey), "-----BEGIN"):
+		block, _ := pem.Decode([]byte(cfgAuth.SigningKey))
+		if block == nil {
+			return nil, errors.New("failed to decode the PEM of the signing key")
+		}
+
+		var err error
+		v.publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the public key of the signing key: %s", err.Error())
+		}
+
+	case cfgAuth.SigningKey != "":
+		v.secret = []byte(cfgAuth.SigningKey)
+
+	default:
+		return nil, errors.New("neither a signing key nor a JWKS URL given")
+	}
+
+	return v, nil
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// decodeSegment decodes a base64url-encoded segment of the token.
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
+
+// Verify checks the signature and the claims of the token at the given time and returns its claims.
+func (v *Verifier) Verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	headerBytes, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed header: %s", err.Error())
+	}
+
+	var hdr header
+	err = json.Unmarshal(headerBytes, &hdr)
+	if err != nil {
+		return nil, fmt.Errorf("malformed header: %s", err.Error())
+	}
+
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %s", err.Error())
+	}
+
+	alg, ok := algorithms[hdr.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm: %#v", hdr.Alg)
+	}
+
+	err = v.verifySignature(hdr.Alg, alg, hdr.Kid, []byte(parts[0]+"."+parts[1]), signature, now)
+	if err != nil {
+		return nil, err
+	}
+
+	claimBytes, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed claims: %s", err.Error())
+	}
+
+	claims := make(map[string]interface{})
+	decoder := json.NewDecoder(strings.NewReader(string(claimBytes)))
+	decoder.UseNumber()
+	err = decoder.Decode(&claims)
+	if err != nil {
+		return nil, fmt.Errorf("malformed claims: %s", err.Error())
+	}
+
+	err = v.checkClaims(claims, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// verifySignature checks the signature with the key of the verifier. The key must match the algorithm:
+// RSA for RS*, ECDSA on the corresponding curve for ES* and, if the key set restricts the key to an algorithm,
+// the algorithm named in the header.
+func (v *Verifier) verifySignature(algName string, alg algorithm, kid string, signed []byte, signature []byte, now time.Time) error {
+	if alg.kind == "HS" {
+		// The shared secret must never be mixed up with a public key, otherwise anybody could forge the tokens.
+		if v.secret == nil {
+			return errors.New("HMAC algorithm is not accepted for the public-key signing key")
+		}
+
+		mac := hmac.New(alg.hash.New, v.secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("invalid signature")
+		}
+
+		return nil
+	}
+
+	publicKey := v.publicKey
+	if v.jwks != nil {
+		var err error
+		var key webKey
+		key, err = v.jwks.key(kid, now)
+		if err != nil {
+			return err
+		}
+
+		if key.alg != "" && key.alg != algName {
+			return fmt.Errorf("the key %#v is restricted to the algorithm %s, but the token uses %s",
+				kid, key.alg, algName)
+		}
+
+		publicKey = key.key
+	}
+
+	if publicKey == nil {
+		return errors.New("public-key algorithm is not accepted for the HMAC signing key")
+	}
+
+	h := alg.hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg.kind {
+	case "RS":
+		rsaKey, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("the signing key is not an RSA key")
+		}
+
+		err := rsa.VerifyPKCS1v15(rsaKey, alg.hash, digest, signature)
+		if err != nil {
+			return errors.New("invalid signature")
+		}
+
+	case "ES":
+		ecKey, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("the signing key is not an ECDSA key")
+		}
+
+		if ecKey.Curve.Params().Name != alg.curve {
+			return fmt.Errorf("the algorithm %s requires the curve %s, but the signing key uses %s",
+				algName, alg.curve, ecKey.Curve.Params().Name)
+		}
+
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature")
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+
+	default:
+		panic(fmt.Sprintf("unhandled kind of the algorithm: %s", alg.kind))
+	}
+
+	return nil
+}
+
+// numericDate parses a claim given as seconds since epoch.
+func numericDate(claims map[string]interface{}, name string) (t time.Time, ok bool, err error) {
+	value, has := claims[name]
+	if !has {
+		return
+	}
+
+	number, isNumber := value.(json.Number)
+	if !isNumber {
+		err = fmt.Errorf("expected the claim %s to be a number, got: %#v", name, value)
+		return
+	}
+
+	seconds, err := number.Float64()
+	if err != nil {
+		err = fmt.Errorf("expected the claim %s to be a number, got: %#v", name, value)
+		return
+	}
+
+	t = time.Unix(0, int64(seconds*float64(time.Second)))
+	ok = true
+	return
+}
+
+func (v *Verifier) checkClaims(claims map[string]interface{}, now time.Time) error {
+	exp, ok, err := numericDate(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !ok && !v.allowMissingExp {
+		return errors.New("the token has no expiration claim")
+	}
+	if ok && now.After(exp.Add(Leeway)) {
+		return errors.New("the token expired")
+	}
+
+	nbf, ok, err := numericDate(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(Leeway).Before(nbf) {
+		return errors.New("the token is not valid yet")
+	}
+
+	if v.issuer != "" {
+		iss, _ := claims["iss"].(string)
+		if iss != v.issuer {
+			return fmt.Errorf("unexpected issuer: %#v", iss)
+		}
+	}
+
+	if v.audience != "" {
+		matches := false
+
+		switch aud := claims["aud"].(type) {
+		case string:
+			matches = aud == v.audience
+
+		case []interface{}:
+			for _, item := range aud {
+				if s, ok := item.(string); ok && s == v.audience {
+					matches = true
+				}
+			}
+		}
+
+		if !matches {
+			return fmt.Errorf("unexpected audience: %#v", claims["aud"])
+		}
+	}
+
+	return nil
+}
+
+// ClaimValue converts the claim to the value of a header.
+func ClaimValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+
+	case json.Number:
+		return value.String()
+
+	default:
+		bb, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Sprintf("%v", value)
+		}
+
+		return string(bb)
+	}
+}
@@ -0,0 +1,404 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Parquery/revproxyry/config"
+)
+
+var now = time.Unix(1700000000, 0)
+
+func encodeSegment(t *testing.T, value interface{}) string {
+	bb, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bb)
+}
+
+// sign creates a token with the given header and claims signed with the key.
+//
+// The key is either a shared secret ([]byte), an *rsa.PrivateKey or an *ecdsa.PrivateKey.
+func sign(t *testing.T, hdr map[string]string, claims map[string]interface{}, key interface{}) string {
+	signed := encodeSegment(t, hdr) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		size := (key.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		s.FillBytes(signature[size:])
+
+	default:
+		t.Fatalf("unexpected key: %T", key)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func publicKeyPEM(t *testing.T, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{"sub": "alice", "exp": now.Add(time.Hour).Unix()}
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("some shared secret")
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := validClaims()
+	expired["exp"] = now.Add(-2 * Leeway).Unix()
+
+	withinLeeway := validClaims()
+	withinLeeway["exp"] = now.Add(-Leeway / 2).Unix()
+
+	missingExp := validClaims()
+	delete(missingExp, "exp")
+
+	notYetValid := validClaims()
+	notYetValid["nbf"] = now.Add(2 * Leeway).Unix()
+
+	tests := []struct {
+		name            string
+		signingKey      string
+		allowMissingExp bool
+		token           string
+		expectedErr     string
+	}{
+		{name: "HS256", signingKey: string(secret),
+			token: sign(t, map[string]string{"alg": "HS256"}, validClaims(), secret)},
+		{name: "RS256", signingKey: publicKeyPEM(t, &rsaKey.PublicKey),
+			token: sign(t, map[string]string{"alg": "RS256"}, validClaims(), rsaKey)},
+		{name: "ES256", signingKey: publicKeyPEM(t, &ecKey.PublicKey),
+			token: sign(t, map[string]string{"alg": "ES256"}, validClaims(), ecKey)},
+		{name: "expired within the leeway", signingKey: string(secret),
+			token: sign(t, map[string]string{"alg": "HS256"}, withinLeeway, secret)},
+		{name: "expired", signingKey: string(secret),
+			token:       sign(t, map[string]string{"alg": "HS256"}, expired, secret),
+			expectedErr: "the token expired"},
+		{name: "not valid yet", signingKey: string(secret),
+			token:       sign(t, map[string]string{"alg": "HS256"}, notYetValid, secret),
+			expectedErr: "the token is not valid yet"},
+		{name: "missing exp", signingKey: string(secret),
+			token:       sign(t, map[string]string{"alg": "HS256"}, missingExp, secret),
+			expectedErr: "the token has no expiration claim"},
+		{name: "missing exp allowed", signingKey: string(secret), allowMissingExp: true,
+			token: sign(t, map[string]string{"alg": "HS256"}, missingExp, secret)},
+		{name: "wrong secret", signingKey: "another secret",
+			token:       sign(t, map[string]string{"alg": "HS256"}, validClaims(), secret),
+			expectedErr: "invalid signature"},
+		{name: "HS256 with a public key", signingKey: publicKeyPEM(t, &rsaKey.PublicKey),
+			token: sign(t, map[string]string{"alg": "HS256"}, validClaims(),
+				[]byte(publicKeyPEM(t, &rsaKey.PublicKey))),
+			expectedErr: "HMAC algorithm is not accepted for the public-key signing key"},
+		{name: "RS256 with a secret", signingKey: string(secret),
+			token:       sign(t, map[string]string{"alg": "RS256"}, validClaims(), rsaKey),
+			expectedErr: "public-key algorithm is not accepted for the HMAC signing key"},
+		{name: "RS256 with an ECDSA key", signingKey: publicKeyPEM(t, &ecKey.PublicKey),
+			token:       sign(t, map[string]string{"alg": "RS256"}, validClaims(), rsaKey),
+			expectedErr: "the signing key is not an RSA key"},
+		{name: "ES384 with a P-256 key", signingKey: publicKeyPEM(t, &ecKey.PublicKey),
+			token: sign(t, map[string]string{"alg": "ES384"}, validClaims(), ecKey),
+			expectedErr: "the algorithm ES384 requires the curve P-384, " +
+				"but the signing key uses P-256"},
+		{name: "none", signingKey: string(secret),
+			token:       encodeSegment(t, map[string]string{"alg": "none"}) + "." + encodeSegment(t, validClaims()) + ".",
+			expectedErr: `unsupported algorithm: "none"`},
+	}
+
+	for _, test := range tests {
+		v, err := New(&config.Auth{
+			Type: config.AuthTypeJWT, SigningKey: test.signingKey, AllowMissingExp: test.allowMissingExp})
+		if err != nil {
+			t.Fatalf("%s: failed to create the verifier: %s", test.name, err.Error())
+		}
+
+		claims, err := v.Verify(test.token, now)
+		switch {
+		case test.expectedErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+
+		case test.expectedErr == "" && claims["sub"] != "alice":
+			t.Errorf("%s: expected the subject alice, got: %#v", test.name, claims["sub"])
+
+		case test.expectedErr != "" && err == nil:
+			t.Errorf("%s: expected the error %#v, got none", test.name, test.expectedErr)
+
+		case test.expectedErr != "" && err.Error() != test.expectedErr:
+			t.Errorf("%s: expected the error %#v, got %#v", test.name, test.expectedErr, err.Error())
+		}
+	}
+}
+
+func TestVerifyIssuerAndAudience(t *testing.T) {
+	secret := []byte("some shared secret")
+	v, err := New(&config.Auth{
+		Type: config.AuthTypeJWT, SigningKey: string(secret), Issuer: "idp", Audience: "revproxyry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := validClaims()
+	claims["iss"] = "idp"
+	claims["aud"] = []string{"other", "revproxyry"}
+
+	_, err = v.Verify(sign(t, map[string]string{"alg": "HS256"}, claims, secret), now)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	claims["iss"] = "another idp"
+	_, err = v.Verify(sign(t, map[string]string{"alg": "HS256"}, claims, secret), now)
+	if err == nil || err.Error() != `unexpected issuer: "another idp"` {
+		t.Errorf("expected an error about the issuer, got: %v", err)
+	}
+
+	claims["iss"] = "idp"
+	claims["aud"] = "other"
+	_, err = v.Verify(sign(t, map[string]string{"alg": "HS256"}, claims, secret), now)
+	if err == nil || err.Error() != `unexpected audience: "other"` {
+		t.Errorf("expected an error about the audience, got: %v", err)
+	}
+}
+
+func ecJWK(kid string, alg string, key *ecdsa.PublicKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{
+		"kid": kid,
+		"kty": "EC",
+		"alg": alg,
+		"crv": key.Curve.Params().Name,
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size)))}
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kid": kid,
+		"kty": "RSA",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func TestVerifyJWKS(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				ecJWK("ec", "ES256", &ecKey.PublicKey),
+				rsaJWK("rsa", &rsaKey.PublicKey)}})
+	}))
+	defer server.Close()
+
+	v, err := New(&config.Auth{Type: config.AuthTypeJWT, JWKSURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		expectedErr string
+	}{
+		{name: "ES256", token: sign(t, map[string]string{"alg": "ES256", "kid": "ec"}, validClaims(), ecKey)},
+		{name: "RS256", token: sign(t, map[string]string{"alg": "RS256", "kid": "rsa"}, validClaims(), rsaKey)},
+		{name: "unknown kid",
+			token:       sign(t, map[string]string{"alg": "ES256", "kid": "rotated"}, validClaims(), ecKey),
+			expectedErr: `unknown key ID: "rotated"`},
+		{name: "missing kid with several keys",
+			token:       sign(t, map[string]string{"alg": "ES256"}, validClaims(), ecKey),
+			expectedErr: `unknown key ID: ""`},
+		{name: "alg of the key",
+			token:       sign(t, map[string]string{"alg": "ES384", "kid": "ec"}, validClaims(), ecKey),
+			expectedErr: `the key "ec" is restricted to the algorithm ES256, but the token uses ES384`},
+		{name: "RS256 with the ECDSA key",
+			token:       sign(t, map[string]string{"alg": "RS256", "kid": "ec"}, validClaims(), rsaKey),
+			expectedErr: `the key "ec" is restricted to the algorithm ES256, but the token uses RS256`},
+		{name: "HS256",
+			token: sign(t, map[string]string{"alg": "HS256", "kid": "ec"}, validClaims(),
+				[]byte("some secret")),
+			expectedErr: "HMAC algorithm is not accepted for the public-key signing key"},
+	}
+
+	for _, test := range tests {
+		_, err := v.Verify(test.token, now)
+		switch {
+		case test.expectedErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+
+		case test.expectedErr != "" && err == nil:
+			t.Errorf("%s: expected the error %#v, got none", test.name, test.expectedErr)
+
+		case test.expectedErr != "" && err.Error() != test.expectedErr:
+			t.Errorf("%s: expected the error %#v, got %#v", test.name, test.expectedErr, err.Error())
+		}
+	}
+
+	// The unknown key ID must not trigger more than a single fetch within the minimum interval.
+	if fetches != 1 {
+		t.Errorf("expected a single fetch of the key set, got %d", fetches)
+	}
+}
+
+func TestJWKSFetchDoesNotBlockKnownKeys(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	requested := make(chan struct{}, 1)
+	fetches := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if fetches > 1 {
+			// Simulate a slow identity provider on the refetch.
+			requested <- struct{}{}
+			<-release
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{ecJWK("ec", "", &ecKey.PublicKey)}})
+	}))
+	defer server.Close()
+	defer close(release)
+
+	v, err := New(&config.Auth{Type: config.AuthTypeJWT, JWKSURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	known := sign(t, map[string]string{"alg": "ES256", "kid": "ec"}, validClaims(), ecKey)
+	unknown := sign(t, map[string]string{"alg": "ES256", "kid": "rotated"}, validClaims(), ecKey)
+
+	_, err = v.Verify(known, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	later := now.Add(2 * jwksMinRefetchInterval)
+
+	unknownErr := make(chan error)
+	go func() {
+		_, err := v.Verify(unknown, later)
+		unknownErr <- err
+	}()
+
+	select {
+	case <-requested:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the unknown key ID to trigger a fetch")
+	}
+
+	verified := make(chan error)
+	go func() {
+		_, err := v.Verify(known, later)
+		verified <- err
+	}()
+
+	select {
+	case err = <-verified:
+		if err != nil {
+			t.Errorf("unexpected error: %s", err.Error())
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the token with the known key ID not to wait for the fetch in flight")
+	}
+
+	release <- struct{}{}
+
+	err = <-unknownErr
+	if err == nil || !strings.HasPrefix(err.Error(), "unknown key ID") {
+		t.Errorf("expected an error about the unknown key ID, got: %v", err)
+	}
+}
+
+func TestJWKSFetchError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	v, err := New(&config.Auth{Type: config.AuthTypeJWT, JWKSURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = v.Verify(sign(t, map[string]string{"alg": "ES256", "kid": "ec"}, validClaims(), ecKey), now)
+
+	expected := fmt.Sprintf("failed to fetch the key set from %s: unexpected status code: 503", server.URL)
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected the error %#v, got: %v", expected, err)
+	}
+}
@@ -0,0 +1,96 @@
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// File represents a log file which is rotated once it exceeds the maximum size.
+type File struct {
+	path    string
+	maxSize int64 // in bytes; if 0, the file is never rotated
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Open opens the log file for appending, creating it if needed.
+//
+// If maxSize is positive, the file is renamed with a timestamp suffix and reopened before it would exceed
+// the maximum size in bytes.
+func Open(path string, maxSize int64) (*File, error) {
+	lf := &File{path: path, maxSize: maxSize}
+
+	err := lf.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return lf, nil
+}
+
+func (lf *File) open() error {
+	f, err := os.OpenFile(lf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	lf.f = f
+	lf.size = stat.Size()
+
+	return nil
+}
+
+// rotate renames the current file with a timestamp suffix and opens a new one.
+func (lf *File) rotate() error {
+	err := lf.f.Close()
+	if err != nil {
+		return err
+	}
+
+	rotatedPath := fmt.Sprintf("%s.%s", lf.path, time.Now().UTC().Format("20060102T150405.000000000Z"))
+
+	renameErr := os.Rename(lf.path, rotatedPath)
+
+	// Reopen the file even if the rename failed so that the subsequent writes do not fail as well.
+	err = lf.open()
+	if err != nil {
+		return err
+	}
+
+	return renameErr
+}
+
+func (lf *File) Write(bytes []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.maxSize > 0 && lf.size > 0 && lf.size+int64(len(bytes)) > lf.maxSize {
+		err := lf.rotate()
+		if err != nil {
+			return 0, fmt.Errorf("failed to rotate the log file %s: %s", lf.path, err.Error())
+		}
+	}
+
+	n, err := lf.f.Write(bytes)
+	lf.size += int64(n)
+
+	return n, err
+}
+
+// Close closes the log file.
+func (lf *File) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	return lf.f.Close()
+}
@@ -1,49 +1,159 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	cryptorand "crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
+	"mime"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
 
+	"github.com/Parquery/revproxyry/acmecache"
+	"github.com/Parquery/revproxyry/auth"
+	"github.com/Parquery/revproxyry/balancer"
+	"github.com/Parquery/revproxyry/circuitbreaker"
+	"github.com/Parquery/revproxyry/clientcert"
+	"github.com/Parquery/revproxyry/clientip"
+	"github.com/Parquery/revproxyry/compression"
 	"github.com/Parquery/revproxyry/config"
+	"github.com/Parquery/revproxyry/forwardproxy"
+	"github.com/Parquery/revproxyry/logfile"
+	"github.com/Parquery/revproxyry/metrics"
+	"github.com/Parquery/revproxyry/ocspstaple"
+	"github.com/Parquery/revproxyry/proxyproto"
+	"github.com/Parquery/revproxyry/ratelimit"
+	"github.com/Parquery/revproxyry/respcache"
+	"github.com/Parquery/revproxyry/retry"
+	"github.com/Parquery/revproxyry/schedule"
+	"github.com/Parquery/revproxyry/setcookie"
 	"github.com/Parquery/revproxyry/sigterm"
-	"github.com/Parquery/revproxyry/auth"
+	"github.com/Parquery/revproxyry/sni"
+	"github.com/Parquery/revproxyry/systemd"
+	"github.com/Parquery/revproxyry/tlscert"
+	"github.com/Parquery/revproxyry/upstream"
 )
 
 type logWriter struct {
 	out io.Writer
+
+	// bare indicates that the lines are written without the "revproxyry: <timestamp>: " prefix.
+	bare bool
 }
 
 func (lw *logWriter) Write(bytes []byte) (int, error) {
+	if lw.bare {
+		return lw.out.Write(bytes)
+	}
+
 	msg := fmt.Sprintf("revproxyry: %s: %s",
 		time.Now().UTC().Format("2006-01-02T15:04:05.999Z"), string(bytes))
 
 	return lw.out.Write([]byte(msg))
 }
 
+// defaultIndexFile is the file served when a directory is requested if none is configured.
+const defaultIndexFile = "index.html"
+
+// immutableCacheControl is the Cache-Control header of the files matching the immutable pattern.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// errorPage represents a custom error page.
+type errorPage struct {
+	contentType string
+	content     []byte
+}
+
+// errorPages maps the status codes to the custom error pages.
+type errorPages map[int]*errorPage
+
+// loadErrorPages reads the custom error pages specified in the config.
+func loadErrorPages(cfgPages map[string]string) (errorPages, error) {
+	pages := make(errorPages)
+	for key, pth := range cfgPages {
+		code, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the status code of the error page: %#v", key)
+		}
+
+		content, err := ioutil.ReadFile(pth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the error page for the status code %d: %s", code, err.Error())
+		}
+
+		contentType := mime.TypeByExtension(filepath.Ext(pth))
+		if contentType == "" {
+			contentType = http.DetectContentType(content)
+		}
+
+		pages[code] = &errorPage{contentType: contentType, content: content}
+	}
+
+	return pages, nil
+}
+
+// serve responds with the custom error page of the status code, if any, or as http.Error with the message otherwise.
+func (ep errorPages) serve(w http.ResponseWriter, message string, code int) {
+	page, ok := ep[code]
+	if !ok {
+		http.Error(w, message, code)
+		return
+	}
+
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Type", page.contentType)
+	header.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	w.Write(page.content)
+}
+
 type fileServer struct {
-	root   http.Dir
-	logErr *log.Logger
+	root             http.Dir
+	single           bool // true if the root is a single file served regardless of the request path
+	indexFile        string
+	directoryListing bool
+	spaFallback      bool
+	cacheControl     string
+	immutablePattern *regexp.Regexp // nil if none
+	errorPages       errorPages
+	logErr           *log.Logger
 }
 
 func (fs *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if fs.single {
+		fs.serveSingle(w, r)
+		return
+	}
+
 	//add prefix and clean
 	upath := r.URL.Path
 	if !strings.HasPrefix(upath, "/") {
@@ -58,30 +168,243 @@ func (fs *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	//check if file exists
 	f, err := os.Open(name)
+	if os.IsNotExist(err) && fs.spaFallback && path.Ext(upath) == "" {
+		// The client-side routes of a single-page app are served the app, while the missing assets are still 404.
+		name = filepath.Join(string(fs.root), fs.indexFile)
+		f, err = os.Open(name)
+	}
 	if err != nil {
-		if os.IsNotExist(err) {
-			http.Error(w, "File not found", http.StatusNotFound)
+		fs.failOpen(w, name, err)
+		return
+	}
+	defer f.Close()
+
+	if !fs.withinRoot(name) {
+		fs.errorPages.serve(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	// Serve the index file instead of the directory, if it exists.
+	stat, err := f.Stat()
+	if err == nil && stat.IsDir() && strings.HasSuffix(r.URL.Path, "/") {
+		indexName := filepath.Join(name, fs.indexFile)
+
+		indexStat, err := os.Stat(indexName)
+		switch {
+		case err == nil && indexStat.Mode().IsRegular() && fs.withinRoot(indexName):
+			name = indexName
+
+		case !fs.directoryListing:
+			fs.errorPages.serve(w, "File not found", http.StatusNotFound)
 			return
 		}
 	}
-	defer f.Close()
+
+	if stat, err := os.Stat(name); err == nil && stat.Mode().IsRegular() {
+		switch {
+		case fs.immutablePattern != nil && fs.immutablePattern.MatchString(upath):
+			w.Header().Set("Cache-Control", immutableCacheControl)
+
+		case fs.cacheControl != "":
+			w.Header().Set("Cache-Control", fs.cacheControl)
+		}
+	}
+
+	if fs.servePrecompressed(w, r, name) {
+		return
+	}
 
 	http.ServeFile(w, r, name)
 }
 
-func newFileServer(root http.Dir, logErr *log.Logger) (*fileServer, error) {
+// serveSingle serves the root file regardless of the request path.
+func (fs *fileServer) serveSingle(w http.ResponseWriter, r *http.Request) {
+	name := string(fs.root)
+
+	f, err := os.Open(name)
+	if err != nil {
+		fs.failOpen(w, name, err)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		fs.errorPages.serve(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case fs.immutablePattern != nil && fs.immutablePattern.MatchString("/"+filepath.Base(name)):
+		w.Header().Set("Cache-Control", immutableCacheControl)
+
+	case fs.cacheControl != "":
+		w.Header().Set("Cache-Control", fs.cacheControl)
+	}
+
+	// The content type is determined by the extension of the file, not of the request path.
+	http.ServeContent(w, r, filepath.Base(name), stat.ModTime(), f)
+}
+
+// failOpen responds to the request for the file which could not be opened.
+//
+// The errors caused by the requested path (e.g., a file requested as a directory) are mapped to 404,
+// while the unexpected errors are logged and mapped to 500.
+func (fs *fileServer) failOpen(w http.ResponseWriter, name string, err error) {
+	switch {
+	case os.IsNotExist(err), errors.Is(err, syscall.ENOTDIR), errors.Is(err, syscall.ENAMETOOLONG):
+		fs.errorPages.serve(w, "File not found", http.StatusNotFound)
+
+	case os.IsPermission(err):
+		fs.errorPages.serve(w, "Forbidden", http.StatusForbidden)
+
+	default:
+		fs.logErr.Printf("Failed to open the file %s: %s\n", name, err.Error())
+		fs.errorPages.serve(w, "Failed to open the file", http.StatusInternalServerError)
+	}
+}
+
+// withinRoot checks whether the file still resides beneath the root after following the symbolic links.
+//
+// Otherwise, a symbolic link in the served directory could expose an arbitrary file of the system.
+func (fs *fileServer) withinRoot(name string) bool {
+	root, err := filepath.EvalSymlinks(string(fs.root))
+	if err != nil {
+		return false
+	}
+
+	resolved, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// servePrecompressed serves the sibling <name>.gz of the file, if it exists and the client accepts gzip.
+//
+// It returns false if the precompressed file has not been served and the original file needs to be served instead.
+func (fs *fileServer) servePrecompressed(w http.ResponseWriter, r *http.Request, name string) bool {
+	stat, err := os.Stat(name)
+	if err != nil || !stat.Mode().IsRegular() {
+		return false
+	}
+
+	gzName := name + ".gz"
+	gzStat, err := os.Stat(gzName)
+	if err != nil || !gzStat.Mode().IsRegular() || !fs.withinRoot(gzName) {
+		return false
+	}
+
+	// The representation depends on the Accept-Encoding since a precompressed file exists.
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if !compression.AcceptsGzip(r) {
+		return false
+	}
+
+	gzFile, err := os.Open(gzName)
+	if err != nil {
+		return false
+	}
+	defer gzFile.Close()
+
+	// The content type is determined by the original file, not by the compressed one.
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		original, err := os.Open(name)
+		if err != nil {
+			return false
+		}
+		defer original.Close()
+
+		var buf [512]byte
+		n, _ := io.ReadFull(original, buf[:])
+		contentType = http.DetectContentType(buf[:n])
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Encoding", "gzip")
+
+	http.ServeContent(w, r, name, gzStat.ModTime(), gzFile)
+	return true
+}
+
+func newFileServer(root http.Dir, route config.Route, pages errorPages, logErr *log.Logger) (*fileServer, error) {
 	if string(root) == "" {
 		return nil, fmt.Errorf("unexpected empty root")
 	}
 
-	return &fileServer{root: root, logErr: logErr}, nil
+	indexFile := route.IndexFile
+	if indexFile == "" {
+		indexFile = defaultIndexFile
+	}
+
+	directoryListing := true
+	if route.DirectoryListing != nil {
+		directoryListing = *route.DirectoryListing
+	}
+
+	var immutablePattern *regexp.Regexp
+	if route.ImmutablePattern != "" {
+		var err error
+		immutablePattern, err = regexp.Compile(route.ImmutablePattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile the immutable pattern: %s", err.Error())
+		}
+	}
+
+	// A regular file is served as-is, everything else is treated as a directory.
+	single := false
+	stat, err := os.Stat(string(root))
+	switch {
+	case err == nil:
+		single = stat.Mode().IsRegular()
+
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to stat the target %s: %s", string(root), err.Error())
+	}
+
+	return &fileServer{
+		root:             root,
+		single:           single,
+		indexFile:        indexFile,
+		directoryListing: directoryListing,
+		spaFallback:      route.SPAFallback,
+		cacheControl:     route.CacheControl,
+		immutablePattern: immutablePattern,
+		errorPages:       pages,
+		logErr:           logErr}, nil
 }
 
+// Sources of the responses as logged in the "source" field of a log message
+const (
+	sourceFile     = "file"
+	sourceProxy    = "proxy"
+	sourceRedirect = "redirect"
+	sourceTunnel   = "tunnel"
+	sourceError    = "error"
+	sourceHealth   = "health"
+)
+
 type loggingHandler struct {
 	logOut  *log.Logger
 	logErr  *log.Logger
 	prefix  string
 	target  string
+	source  string
+
+	// sampleRate is the fraction of the successful requests which are logged.
+	sampleRate float64
+
+	// logHeaders indicates whether the request and response headers are logged.
+	logHeaders bool
+
 	handler http.Handler
 }
 
@@ -89,64 +412,178 @@ type logMessage struct {
 	Method         string `json:"method"`
 	URL            string `json:"url"`
 	RemoteAddr     string `json:"remote_addr"`
+	RequestID      string `json:"request_id"`
 	Prefix         string `json:"prefix"`
 	Target         string `json:"target"`
 	Error          string `json:"error"`
 	StatusCode     int    `json:"status_code"`
 	RedirectionURL string `json:"redirection_url"`
+	Source         string `json:"source"`
+
+	// DurationMs is the time elapsed while handling the request in milliseconds.
+	DurationMs float64 `json:"duration_ms"`
+
+	// BytesWritten is the size of the response body written to the client, or the bytes tunneled to the client
+	// for a CONNECT request.
+	BytesWritten int64 `json:"bytes_written"`
+
+	// RequestHeaders and ResponseHeaders are only logged on the routes with log_headers.
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+
+	// The following fields are only logged in the combined format.
+	time       time.Time
+	requestURI string
+	proto      string
+	referer    string
+	userAgent  string
 }
 
-func newMessage(req *http.Request) logMessage {
-	return logMessage{
-		Method:     req.Method,
-		URL:        req.URL.String(),
-		RemoteAddr: req.RemoteAddr}
+// messageFormat is the format of the lines logging the requests. It is set once on start from log_format of
+// the config; the other lines are always free-form text.
+var messageFormat = config.LogFormatJSON
+
+// encode encodes the message in the configured format.
+func (msg *logMessage) encode() ([]byte, error) {
+	switch messageFormat {
+	case config.LogFormatPlain:
+		return []byte(msg.plain()), nil
+
+	case config.LogFormatCombined:
+		return []byte(msg.combined()), nil
+
+	default:
+		return json.Marshal(msg)
+	}
 }
 
-type loggingResponseWriter struct {
-	http.ResponseWriter
-	statusCode int
+// plain formats the message as a concise line, e.g., "GET /index.html 200 1.234ms 512B remote=...".
+func (msg *logMessage) plain() string {
+	uri := msg.requestURI
+	if uri == "" {
+		uri = msg.URL
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %d %.3fms %dB remote=%s", msg.Method, uri, msg.StatusCode, msg.DurationMs,
+		msg.BytesWritten, msg.RemoteAddr)
+
+	fields := []struct {
+		key   string
+		value string
+	}{
+		{key: "id", value: msg.RequestID},
+		{key: "prefix", value: msg.Prefix},
+		{key: "target", value: msg.Target},
+		{key: "source", value: msg.Source},
+		{key: "redirection_url", value: msg.RedirectionURL},
+	}
+
+	for _, field := range fields {
+		if field.value != "" {
+			fmt.Fprintf(&b, " %s=%s", field.key, field.value)
+		}
+	}
+
+	if msg.Error != "" {
+		fmt.Fprintf(&b, " error=%q", msg.Error)
+	}
+
+	return b.String()
 }
 
-func (lrw *loggingResponseWriter) WriteHeader(code int) {
-	lrw.statusCode = code
-	lrw.ResponseWriter.WriteHeader(code)
+// combinedEscaper escapes the quoted fields of the combined log format.
+var combinedEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// combined formats the message in the Combined Log Format of Apache.
+func (msg *logMessage) combined() string {
+	host := msg.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		host = "-"
+	}
+
+	uri := msg.requestURI
+	if uri == "" {
+		uri = msg.URL
+	}
+
+	size := "-"
+	if msg.BytesWritten > 0 {
+		size = strconv.FormatInt(msg.BytesWritten, 10)
+	}
+
+	quoted := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return combinedEscaper.Replace(s)
+	}
+
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s "%s" "%s"`,
+		host, msg.time.Format("02/Jan/2006:15:04:05 -0700"),
+		combinedEscaper.Replace(msg.Method), combinedEscaper.Replace(uri), msg.proto,
+		msg.StatusCode, size, quoted(msg.referer), quoted(msg.userAgent))
 }
 
-func (h *loggingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: 0}
+// redactedHeaders carry the credentials and are never logged in plain.
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	"X-Auth-Token":        true,
+}
 
-	h.handler.ServeHTTP(lrw, req)
+// sanitizeHeaders flattens the header for the log and redacts the credentials.
+func sanitizeHeaders(header http.Header) map[string]string {
+	result := make(map[string]string, len(header))
+	for key, values := range header {
+		if redactedHeaders[http.CanonicalHeaderKey(key)] {
+			result[key] = "[REDACTED]"
+			continue
+		}
 
-	msg := newMessage(req)
-	msg.Prefix = h.prefix
-	msg.Target = h.target
-	msg.StatusCode = lrw.statusCode
+		result[key] = strings.Join(values, ", ")
+	}
 
-	bb, err := json.Marshal(&msg)
-	if err != nil {
-		http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
-		h.logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
-		return
+	return result
+}
+
+// clientIPKey is the context key of the client IP resolved behind the trusted proxies.
+type clientIPKey struct{}
+
+// remoteAddr returns the client IP resolved behind the trusted proxies, if any, or the address of the direct peer.
+func remoteAddr(req *http.Request) string {
+	if clientIP, ok := req.Context().Value(clientIPKey{}).(string); ok {
+		return clientIP
 	}
 
-	h.logOut.Printf("%s\n", string(bb))
+	return req.RemoteAddr
 }
 
-type authHandler struct {
-	auths   *auth.Auths
-	logErr  *log.Logger
-	handler http.Handler
+// concurrencyHandler limits the number of requests handled simultaneously.
+type concurrencyHandler struct {
+	semaphore chan struct{}
+	logErr    *log.Logger
+	handler   http.Handler
 }
 
-func (h *authHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	username, passw, ok := req.BasicAuth()
-	if !ok {
+func (h *concurrencyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	select {
+	case h.semaphore <- struct{}{}:
+		defer func() { <-h.semaphore }()
+
+	default:
 		msg := newMessage(req)
-		msg.Error = "no Auth"
-		msg.StatusCode = http.StatusUnauthorized
+		msg.Error = "too many concurrent requests"
+		msg.Source = sourceError
+		msg.StatusCode = http.StatusServiceUnavailable
 
-		bb, err := json.Marshal(&msg)
+		bb, err := msg.encode()
 		if err != nil {
 			http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
 			h.logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
@@ -155,209 +592,2804 @@ func (h *authHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 		h.logErr.Printf("%s\n", string(bb))
 
-		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-		http.Error(w, "No basic Auth provided", http.StatusUnauthorized)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "The server is overloaded", http.StatusServiceUnavailable)
 		return
 	}
 
-	var rejectionMsg string
-	var err error
-	ok, rejectionMsg, err = h.auths.Authenticate(username, passw)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to authenticate the user: %s", username),
-			http.StatusInternalServerError)
-		h.logErr.Printf("Failed to authenticate the user %s: %s", username, err.Error())
-		return
+	h.handler.ServeHTTP(w, req)
+}
+
+// headerReadSlack multiplies the header limit to give the header limit of the servers.
+const headerReadSlack = 2
+
+// headerSize approximates the size of the request line and headers of the request as sent over HTTP/1.1.
+//
+// The name and the size of the largest header are returned as well.
+func headerSize(req *http.Request) (size int, largest string, largestSize int) {
+	size = len(req.Method) + len(req.RequestURI) + len(req.Proto) + 4
+
+	if req.Host != "" {
+		size += len("Host: ") + len(req.Host) + 2
 	}
 
-	if !ok {
-		msg := newMessage(req)
-		msg.Error = fmt.Sprintf("Auth not accepted for the user %s: %s", username, rejectionMsg)
-		msg.StatusCode = http.StatusUnauthorized
+	for name, values := range req.Header {
+		total := 0
+		for _, value := range values {
+			total += len(name) + len(value) + 4
+		}
 
-		bb, err := json.Marshal(&msg)
-		if err != nil {
-			http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
-			h.logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
-			return
+		size += total
+		if total > largestSize {
+			largest, largestSize = name, total
 		}
+	}
 
-		h.logErr.Printf("%s\n", string(bb))
+	return size, largest, largestSize
+}
 
-		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-		http.Error(w, "Provided basic Auth not accepted", http.StatusUnauthorized)
+// headerLimitHandler rejects the requests whose headers exceed the limit with 431 Request Header Fields Too Large
+// and logs them together with the largest header so that the limit can be sized.
+type headerLimitHandler struct {
+	limit   int
+	logErr  *log.Logger
+	handler http.Handler
+}
 
+func (h *headerLimitHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	size, largest, largestSize := headerSize(req)
+	if size <= h.limit {
+		h.handler.ServeHTTP(w, req)
 		return
 	}
 
+	msg := newMessage(req)
+	msg.Error = fmt.Sprintf("request headers too large: %d bytes exceed the limit of %d bytes, "+
+		"the largest header is %s with %d bytes", size, h.limit, largest, largestSize)
+	msg.Source = sourceError
+	msg.StatusCode = http.StatusRequestHeaderFieldsTooLarge
+
+	bb, err := msg.encode()
+	if err != nil {
+		h.logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
+	} else {
+		h.logErr.Printf("%s\n", string(bb))
+	}
+
+	// The connection is closed as net/http does for the requests over its limit.
+	w.Header().Set("Connection", "close")
+	http.Error(w, "Request header fields too large", http.StatusRequestHeaderFieldsTooLarge)
+}
+
+// hstsHandler adds the Strict-Transport-Security header to the responses of the HTTPS server.
+type hstsHandler struct {
+	value   string
+	handler http.Handler
+}
+
+func newHSTSHandler(hsts *config.HSTS, handler http.Handler) *hstsHandler {
+	value := fmt.Sprintf("max-age=%d", hsts.MaxAgeSeconds)
+	if hsts.IncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if hsts.Preload {
+		value += "; preload"
+	}
+
+	return &hstsHandler{value: value, handler: handler}
+}
+
+func (h *hstsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Strict-Transport-Security", h.value)
 	h.handler.ServeHTTP(w, req)
 }
 
-type args struct {
-	revproxyPath *string
-	quiet        *bool
+// securityHeadersHandler adds the security headers to the responses.
+//
+// The headers already set by the wrapped handler, e.g., by the upstream, take precedence.
+type securityHeadersHandler struct {
+	headers map[string]string
+	handler http.Handler
+}
+
+func newSecurityHeadersHandler(sh *config.SecurityHeaders, handler http.Handler) *securityHeadersHandler {
+	headers := make(map[string]string)
+	for key, value := range map[string]string{
+		"X-Content-Type-Options":  sh.ContentTypeOptions,
+		"X-Frame-Options":         sh.FrameOptions,
+		"Referrer-Policy":         sh.ReferrerPolicy,
+		"Content-Security-Policy": sh.ContentSecurityPolicy} {
+		if value != "" {
+			headers[key] = value
+		}
+	}
+
+	return &securityHeadersHandler{headers: headers, handler: handler}
 }
 
-func setupRouter(cfg *config.Config, logOut *log.Logger, logErr *log.Logger) (http.Handler, error) {
+func (h *securityHeadersHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.handler.ServeHTTP(&securityHeadersWriter{ResponseWriter: w, headers: h.headers}, req)
+}
 
-	router := http.NewServeMux()
+// securityHeadersWriter sets the missing security headers just before the header is written.
+type securityHeadersWriter struct {
+	http.ResponseWriter
+	headers map[string]string
+	written bool
+}
 
-	handledRoot := false
+func (sw *securityHeadersWriter) setHeaders() {
+	if sw.written {
+		return
+	}
+	sw.written = true
 
-	for _, route := range cfg.Routes {
+	header := sw.ResponseWriter.Header()
+	for key, value := range sw.headers {
+		if header.Get(key) == "" {
+			header.Set(key, value)
+		}
+	}
+}
+
+func (sw *securityHeadersWriter) WriteHeader(code int) {
+	// The informational (1xx) responses precede the final header.
+	if code >= 200 {
+		sw.setHeaders()
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
 
-		parsedURL, _ := url.ParseRequestURI(route.Target)
+func (sw *securityHeadersWriter) Write(bytes []byte) (int, error) {
+	sw.setHeaders()
+	return sw.ResponseWriter.Write(bytes)
+}
 
-		var handler http.Handler
+// ReadFrom delegates to the underlying response writer so that http.ServeFile can still use sendfile.
+func (sw *securityHeadersWriter) ReadFrom(r io.Reader) (int64, error) {
+	sw.setHeaders()
+	if rf, ok := sw.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(sw.ResponseWriter, r)
+}
 
-		switch {
-		case strings.HasPrefix(route.Target, "/"):
-			var err error
-			handler, err = newFileServer(http.Dir(route.Target), logErr)
-			if err != nil {
-				return nil, err
-			}
+// Unwrap gives http.ResponseController access to the underlying response writer (e.g., for flushing).
+func (sw *securityHeadersWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// strippedServerHeaders lists the response headers revealing the software of the upstreams.
+var strippedServerHeaders = []string{"Server", "X-Powered-By"}
+
+// stripHeadersHandler removes the headers from the responses.
+type stripHeadersHandler struct {
+	headers []string
+	handler http.Handler
+}
+
+func (h *stripHeadersHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.handler.ServeHTTP(&stripHeadersWriter{ResponseWriter: w, headers: h.headers}, req)
+}
+
+// stripHeadersWriter removes the headers just before the header is written.
+type stripHeadersWriter struct {
+	http.ResponseWriter
+	headers []string
+}
+
+func (sw *stripHeadersWriter) strip() {
+	header := sw.ResponseWriter.Header()
+	for _, key := range sw.headers {
+		header.Del(key)
+	}
+}
+
+func (sw *stripHeadersWriter) WriteHeader(code int) {
+	sw.strip()
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *stripHeadersWriter) Write(bytes []byte) (int, error) {
+	sw.strip()
+	return sw.ResponseWriter.Write(bytes)
+}
+
+// ReadFrom delegates to the underlying response writer so that http.ServeFile can still use sendfile.
+func (sw *stripHeadersWriter) ReadFrom(r io.Reader) (int64, error) {
+	sw.strip()
+	if rf, ok := sw.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(sw.ResponseWriter, r)
+}
+
+// Unwrap gives http.ResponseController access to the underlying response writer (e.g., for flushing).
+func (sw *stripHeadersWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// traceBlockHandler rejects the TRACE requests with 405 Method Not Allowed so that the requests, including
+// their credentials, are never echoed back by the upstreams.
+type traceBlockHandler struct {
+	// allow lists the allowed methods in the Allow header.
+	allow string
+
+	logErr  *log.Logger
+	handler http.Handler
+}
+
+// defaultTraceAllow lists the allowed methods of the blocked TRACE requests to the routes which do not restrict
+// the methods.
+const defaultTraceAllow = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS, CONNECT"
+
+// newTraceBlockHandler creates the handler blocking the TRACE requests to a route with the given allowed methods.
+// If no methods are given, all the methods except TRACE are listed as allowed.
+func newTraceBlockHandler(methods []string, logErr *log.Logger, handler http.Handler) *traceBlockHandler {
+	allow := defaultTraceAllow
+	if len(methods) > 0 {
+		allowed := make([]string, 0, len(methods))
+		for _, method := range methods {
+			if method != http.MethodTrace {
+				allowed = append(allowed, method)
+			}
+		}
+
+		allow = strings.Join(allowed, ", ")
+	}
+
+	return &traceBlockHandler{allow: allow, logErr: logErr, handler: handler}
+}
+
+func (h *traceBlockHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodTrace {
+		h.handler.ServeHTTP(w, req)
+		return
+	}
+
+	msg := newMessage(req)
+	msg.Error = "TRACE is blocked"
+	msg.Source = sourceError
+	msg.StatusCode = http.StatusMethodNotAllowed
+
+	bb, err := msg.encode()
+	if err != nil {
+		h.logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
+	} else {
+		h.logErr.Printf("%s\n", string(bb))
+	}
+
+	w.Header().Set("Allow", h.allow)
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// defaultRequestIDHeader is the header carrying the request ID if none is configured.
+const defaultRequestIDHeader = "X-Request-Id"
+
+// requestIDKey is the context key of the ID of the request.
+type requestIDKey struct{}
+
+// maxRequestIDLength limits the length of the request IDs accepted from the clients.
+const maxRequestIDLength = 128
+
+// validRequestID checks that the request ID given by the client is short and printable so that it can be
+// safely forwarded and logged.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID generates a random UUID (version 4).
+func newRequestID() (string, error) {
+	var b [16]byte
+	_, err := cryptorand.Read(b[:])
+	if err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// requestIDHandler assigns an ID to every request which is forwarded upstream, returned in the response
+// and logged.
+//
+// The ID given by the client is kept if it is valid. Otherwise, a new one is generated.
+type requestIDHandler struct {
+	header  string
+	handler http.Handler
+}
+
+func (h *requestIDHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	id := req.Header.Get(h.header)
+	if !validRequestID(id) {
+		var err error
+		id, err = newRequestID()
+		if err != nil {
+			// The request is still served, only without an ID.
+			h.handler.ServeHTTP(w, req)
+			return
+		}
+
+		req.Header.Set(h.header, id)
+	}
+
+	w.Header().Set(h.header, id)
+
+	h.handler.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id)))
+}
+
+// clientIPHandler resolves the client IP behind the trusted proxies and stores it in the request context.
+type clientIPHandler struct {
+	resolver *clientip.Resolver
+	handler  http.Handler
+}
+
+func (h *clientIPHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	clientIP := h.resolver.Resolve(req)
+	if clientIP != "" {
+		req = req.WithContext(context.WithValue(req.Context(), clientIPKey{}, clientIP))
+	}
+
+	h.handler.ServeHTTP(w, req)
+}
+
+func newMessage(req *http.Request) logMessage {
+	requestID, _ := req.Context().Value(requestIDKey{}).(string)
+
+	return logMessage{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		RemoteAddr: remoteAddr(req),
+		RequestID:  requestID,
+		time:       time.Now(),
+		requestURI: req.RequestURI,
+		proto:      req.Proto,
+		referer:    req.Referer(),
+		userAgent:  req.UserAgent()}
+}
+
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	statusCode   int
+	bytesWritten int64
+}
+
+func (lrw *loggingResponseWriter) WriteHeader(code int) {
+	// Only the first final status code is sent by net/http; the informational (1xx) ones precede it.
+	if lrw.statusCode < 200 {
+		lrw.statusCode = code
+	}
+	lrw.ResponseWriter.WriteHeader(code)
+}
+
+func (lrw *loggingResponseWriter) Write(bytes []byte) (int, error) {
+	// As in net/http, writing without an explicit WriteHeader implies 200 OK.
+	if lrw.statusCode == 0 {
+		lrw.statusCode = http.StatusOK
+	}
+
+	n, err := lrw.ResponseWriter.Write(bytes)
+	lrw.bytesWritten += int64(n)
+	return n, err
+}
+
+// ReadFrom delegates to the underlying response writer so that io.Copy in http.ServeFile can still use sendfile.
+func (lrw *loggingResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if lrw.statusCode == 0 {
+		lrw.statusCode = http.StatusOK
+	}
+
+	var n int64
+	var err error
+
+	if rf, ok := lrw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(lrw.ResponseWriter, r)
+	}
+
+	lrw.bytesWritten += n
+	return n, err
+}
+
+// Unwrap gives http.ResponseController access to the underlying response writer (e.g., for flushing).
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
+// Hijack hands the connection over to the handler (e.g., to tunnel a CONNECT request) while still counting
+// the bytes written to the client on it. The bytes written through the returned buffer are not counted.
+func (lrw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buffered, err := http.NewResponseController(lrw.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &countingConn{Conn: conn, lrw: lrw}, buffered, nil
+}
+
+// countingConn counts the bytes written to a hijacked connection as the bytes written by the response writer.
+//
+// The handler must not return before it stopped writing since the count is read afterwards.
+type countingConn struct {
+	net.Conn
+	lrw *loggingResponseWriter
+}
+
+func (c *countingConn) Write(bytes []byte) (int, error) {
+	n, err := c.Conn.Write(bytes)
+	c.lrw.bytesWritten += int64(n)
+	return n, err
+}
+
+// CloseWrite half-closes the underlying connection, if supported, so that the tunnels can still be read.
+func (c *countingConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return c.Conn.Close()
+}
+
+func (h *loggingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: 0}
+
+	// The request headers are captured before the proxy modifies them for the upstream.
+	var requestHeaders map[string]string
+	if h.logHeaders {
+		requestHeaders = sanitizeHeaders(req.Header)
+	}
+
+	start := time.Now()
+	h.handler.ServeHTTP(lrw, req)
+	elapsed := time.Since(start)
+
+	// net/http responds with 200 OK if the handler neither wrote the header nor the body.
+	if lrw.statusCode == 0 {
+		lrw.statusCode = http.StatusOK
+	}
+
+	msg := newMessage(req)
+	msg.time = start
+	msg.Prefix = h.prefix
+	msg.Target = h.target
+	msg.Source = h.source
+	msg.StatusCode = lrw.statusCode
+	msg.DurationMs = float64(elapsed.Microseconds()) / 1000.0
+	msg.BytesWritten = lrw.bytesWritten
+
+	if h.source == sourceRedirect {
+		msg.RedirectionURL = lrw.Header().Get("Location")
+	}
+
+	if h.logHeaders {
+		msg.RequestHeaders = requestHeaders
+		msg.ResponseHeaders = sanitizeHeaders(w.Header())
+	}
+
+	// The errors are always logged, while only a sample of the successful requests is.
+	if msg.StatusCode < 400 && h.sampleRate < 1 && rand.Float64() >= h.sampleRate {
+		return
+	}
+
+	bb, err := msg.encode()
+	if err != nil {
+		http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
+		h.logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
+		return
+	}
+
+	h.logOut.Printf("%s\n", string(bb))
+}
+
+// defaultRealm is the realm of the authentication prompts if none is configured.
+const defaultRealm = "Restricted"
+
+// realmEscaper escapes the realm as a quoted string of the WWW-Authenticate header.
+var realmEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+type authHandler struct {
+	auths *auth.Auths
+	realm string
+
+	// proxy indicates that the credentials are given in the Proxy-Authorization header and challenged with
+	// 407 Proxy Authentication Required as by a forward proxy.
+	proxy bool
+
+	// failures blocks the clients which failed to authenticate too often. If nil, the failures are not limited.
+	failures *ratelimit.Failures
+
+	logErr  *log.Logger
+	handler http.Handler
+}
+
+// bearerToken returns the token of the Authorization header, if it uses the bearer scheme.
+func bearerToken(req *http.Request) (token string, ok bool) {
+	const scheme = "Bearer "
+
+	header := req.Header.Get("Authorization")
+	if len(header) < len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return "", false
+	}
+
+	return strings.TrimSpace(header[len(scheme):]), true
+}
+
+// reject logs the authentication failure and responds with 401 (407 for a forward proxy) and the challenge
+// of the route.
+func (h *authHandler) reject(w http.ResponseWriter, req *http.Request, reason string, response string) {
+	status := http.StatusUnauthorized
+	challengeHeader := "WWW-Authenticate"
+	if h.proxy {
+		status = http.StatusProxyAuthRequired
+		challengeHeader = "Proxy-Authenticate"
+	}
+
+	msg := newMessage(req)
+	msg.Error = reason
+	msg.Source = sourceError
+	msg.StatusCode = status
+
+	bb, err := msg.encode()
+	if err != nil {
+		http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
+		h.logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
+		return
+	}
+
+	h.logErr.Printf("%s\n", string(bb))
+
+	scheme := "Basic"
+	if !h.auths.HasBasic() {
+		scheme = "Bearer"
+	}
+	w.Header().Set(challengeHeader, fmt.Sprintf(`%s realm="%s"`, scheme, realmEscaper.Replace(h.realm)))
+	http.Error(w, response, status)
+}
+
+// fail records the failed authentication of the client and rejects the request.
+func (h *authHandler) fail(w http.ResponseWriter, req *http.Request, reason string, response string) {
+	if h.failures != nil {
+		h.failures.Fail(clientHost(req), time.Now())
+	}
+
+	h.reject(w, req, reason, response)
+}
+
+// succeed forgets the failed authentications of the client and serves the request.
+func (h *authHandler) succeed(w http.ResponseWriter, req *http.Request) {
+	if h.failures != nil {
+		h.failures.Reset(clientHost(req))
+	}
+
+	h.handler.ServeHTTP(w, req)
+}
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, header := range h.auths.ClaimHeaders() {
+		req.Header.Del(header)
+	}
+
+	if h.proxy {
+		// The credentials for the proxy are checked as if they were given for the route.
+		req.Header.Del("Authorization")
+		if credentials := req.Header.Get("Proxy-Authorization"); credentials != "" {
+			req.Header.Set("Authorization", credentials)
+		}
+		req.Header.Del("Proxy-Authorization")
+	}
+
+	// Reject the blocked clients before even checking the credentials so that they do not burn the CPU on hashing.
+	if h.failures != nil && h.failures.Blocked(clientHost(req), time.Now()) {
+		msg := newMessage(req)
+		msg.Error = "too many failed authentications"
+		msg.Source = sourceError
+		msg.StatusCode = http.StatusTooManyRequests
+
+		bb, err := msg.encode()
+		if err != nil {
+			http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
+			h.logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
+			return
+		}
+
+		h.logErr.Printf("%s\n", string(bb))
+
+		http.Error(w, "Too many failed authentications", http.StatusTooManyRequests)
+		return
+	}
+
+	if token, ok := bearerToken(req); ok && h.auths.HasBearer() {
+		ok, rejectionMsg, headers, err := h.auths.AuthenticateBearer(token)
+		if err != nil {
+			http.Error(w, "Failed to authenticate the bearer token", http.StatusInternalServerError)
+			h.logErr.Printf("Failed to authenticate the bearer token: %s", err.Error())
+			return
+		}
+
+		if !ok {
+			h.fail(w, req, fmt.Sprintf("Auth not accepted for the bearer token: %s", rejectionMsg),
+				"Provided bearer token not accepted")
+			return
+		}
+
+		for header, value := range headers {
+			req.Header.Set(header, value)
+		}
+
+		h.succeed(w, req)
+		return
+	}
+
+	if !h.auths.HasBasic() {
+		h.reject(w, req, "no Auth", "No bearer token provided")
+		return
+	}
+
+	username, passw, ok := req.BasicAuth()
+	if !ok {
+		h.reject(w, req, "no Auth", "No basic Auth provided")
+		return
+	}
+
+	var rejectionMsg string
+	var err error
+	ok, rejectionMsg, err = h.auths.Authenticate(username, passw)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to authenticate the user: %s", username),
+			http.StatusInternalServerError)
+		h.logErr.Printf("Failed to authenticate the user %s: %s", username, err.Error())
+		return
+	}
+
+	if !ok {
+		h.fail(w, req, fmt.Sprintf("Auth not accepted for the user %s: %s", username, rejectionMsg),
+			"Provided basic Auth not accepted")
+		return
+	}
+
+	h.succeed(w, req)
+}
+
+type scheduleHandler struct {
+	schedule *schedule.Schedule
+	logErr   *log.Logger
+	handler  http.Handler
+}
+
+func (h *scheduleHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !h.schedule.Allows(time.Now()) {
+		msg := newMessage(req)
+		msg.Error = "outside of the access schedule"
+		msg.Source = sourceError
+		msg.StatusCode = http.StatusServiceUnavailable
+
+		bb, err := msg.encode()
+		if err != nil {
+			http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
+			h.logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
+			return
+		}
+
+		h.logErr.Printf("%s\n", string(bb))
+
+		http.Error(w, "The resource is not accessible at this time", http.StatusServiceUnavailable)
+		return
+	}
+
+	h.handler.ServeHTTP(w, req)
+}
+
+// rateLimitEvictionInterval is the interval between two evictions of the idle clients from the rate limiters.
+const rateLimitEvictionInterval = time.Minute
+
+// clientHost returns the client IP resolved behind the trusted proxies, if any, or the IP of the direct peer.
+func clientHost(req *http.Request) string {
+	addr := remoteAddr(req)
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+type rateLimitHandler struct {
+	limiter *ratelimit.Limiter
+	logErr  *log.Logger
+	handler http.Handler
+}
+
+func (h *rateLimitHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !h.limiter.Allow(clientHost(req), time.Now()) {
+		msg := newMessage(req)
+		msg.Error = "rate limit exceeded"
+		msg.Source = sourceError
+		msg.StatusCode = http.StatusTooManyRequests
+
+		bb, err := msg.encode()
+		if err != nil {
+			http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
+			h.logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
+			return
+		}
+
+		h.logErr.Printf("%s\n", string(bb))
+
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	h.handler.ServeHTTP(w, req)
+}
+
+// bodyLimitHandler rejects the requests whose body exceeds the limit with 413 Request Entity Too Large.
+//
+// The requests announcing a larger Content-Length are rejected upfront. The other bodies are limited while they are
+// read so that the handler fails once the limit is exceeded.
+type bodyLimitHandler struct {
+	limit   int64
+	logErr  *log.Logger
+	handler http.Handler
+}
+
+func (h *bodyLimitHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.ContentLength > h.limit {
+		logTooLarge(req, h.logErr)
+		http.Error(w, "Request entity too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = http.MaxBytesReader(w, req.Body, h.limit)
+	}
+
+	h.handler.ServeHTTP(w, req)
+}
+
+// logTooLarge logs that the body of the request exceeded the limit.
+func logTooLarge(req *http.Request, logErr *log.Logger) {
+	msg := newMessage(req)
+	msg.Error = "request body too large"
+	msg.Source = sourceError
+	msg.StatusCode = http.StatusRequestEntityTooLarge
+
+	bb, err := msg.encode()
+	if err != nil {
+		logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
+		return
+	}
+
+	logErr.Printf("%s\n", string(bb))
+}
+
+// newBodyLimitErrorHandler wraps the error handler of a proxy so that the request bodies exceeding the limit
+// while being sent upstream are answered with 413 instead of 502.
+//
+// If the next error handler is nil, the other errors are logged and answered with 502 as by httputil.ReverseProxy.
+func newBodyLimitErrorHandler(next func(http.ResponseWriter, *http.Request, error),
+	logErr *log.Logger) func(http.ResponseWriter, *http.Request, error) {
+
+	return func(w http.ResponseWriter, req *http.Request, err error) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			logTooLarge(req, logErr)
+			http.Error(w, "Request entity too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if next != nil {
+			next(w, req, err)
+			return
+		}
+
+		logErr.Printf("http: proxy error: %s\n", err.Error())
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
+
+// newCircuitOpenErrorHandler wraps the error handler of a proxy so that the requests rejected by an open circuit
+// are answered with 503 instead of 502.
+//
+// If the next error handler is nil, the other errors are logged and answered with 502 as by httputil.ReverseProxy.
+func newCircuitOpenErrorHandler(next func(http.ResponseWriter, *http.Request, error), pages errorPages,
+	logErr *log.Logger) func(http.ResponseWriter, *http.Request, error) {
+
+	return func(w http.ResponseWriter, req *http.Request, err error) {
+		if errors.Is(err, circuitbreaker.ErrOpen) {
+			pages.serve(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		if next != nil {
+			next(w, req, err)
+			return
+		}
+
+		logErr.Printf("http: proxy error: %s\n", err.Error())
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
+
+// methodHandler rejects the requests whose method is not allowed with 405 Method Not Allowed.
+type methodHandler struct {
+	methods map[string]bool
+
+	// allow lists the allowed methods in the Allow header.
+	allow string
+
+	logErr  *log.Logger
+	handler http.Handler
+}
+
+func newMethodHandler(methods []string, logErr *log.Logger, handler http.Handler) *methodHandler {
+	set := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		set[method] = true
+	}
+
+	return &methodHandler{
+		methods: set,
+		allow:   strings.Join(methods, ", "),
+		logErr:  logErr,
+		handler: handler}
+}
+
+func (h *methodHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !h.methods[req.Method] {
+		msg := newMessage(req)
+		msg.Error = fmt.Sprintf("method not allowed: %s", req.Method)
+		msg.Source = sourceError
+		msg.StatusCode = http.StatusMethodNotAllowed
+
+		bb, err := msg.encode()
+		if err != nil {
+			http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
+			h.logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
+			return
+		}
+
+		h.logErr.Printf("%s\n", string(bb))
+
+		w.Header().Set("Allow", h.allow)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.handler.ServeHTTP(w, req)
+}
+
+type encodedSlashHandler struct {
+	mode    string
+	logErr  *log.Logger
+	handler http.Handler
+}
+
+func (h *encodedSlashHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !strings.Contains(strings.ToLower(req.URL.EscapedPath()), "%2f") {
+		h.handler.ServeHTTP(w, req)
+		return
+	}
+
+	switch h.mode {
+	case config.EncodedSlashesReject:
+		msg := newMessage(req)
+		msg.Error = "encoded slash in the path"
+		msg.Source = sourceError
+		msg.StatusCode = http.StatusBadRequest
+
+		bb, err := msg.encode()
+		if err != nil {
+			http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
+			h.logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
+			return
+		}
+
+		h.logErr.Printf("%s\n", string(bb))
+
+		http.Error(w, "Encoded slashes are not allowed in the path", http.StatusBadRequest)
+		return
+
+	case config.EncodedSlashesDecode:
+		// The path is already decoded, so we only need to drop the raw one.
+		req.URL.RawPath = ""
+
+	case config.EncodedSlashesRaw:
+		// Nothing to do; the raw path is passed on as-is.
+
+	default:
+		panic(fmt.Sprintf("unhandled encoded slashes mode: %#v", h.mode))
+	}
+
+	h.handler.ServeHTTP(w, req)
+}
+
+// noWriteTimeoutHandler lifts the write timeout of the server for the requests it handles.
+type noWriteTimeoutHandler struct {
+	logErr  *log.Logger
+	handler http.Handler
+}
+
+func (h *noWriteTimeoutHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		h.logErr.Printf("Failed to lift the write timeout for %s: %s\n", req.URL.String(), err.Error())
+	}
+
+	h.handler.ServeHTTP(w, req)
+}
+
+// metricsHandler observes the handled requests in the metrics registry.
+//
+// It wraps the whole handler of a route so that the responses of revproxyry itself (e.g., 401 of a failed
+// authentication or 429 of the rate limit) are observed as well as the responses of the targets.
+type metricsHandler struct {
+	metrics *metrics.Registry
+	prefix  string
+	handler http.Handler
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: 0}
+
+	start := time.Now()
+	h.handler.ServeHTTP(lrw, req)
+	elapsed := time.Since(start)
+
+	// net/http responds with 200 OK if the handler neither wrote the header nor the body.
+	statusCode := lrw.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	h.metrics.Observe(h.prefix, statusCode, elapsed)
+}
+
+// tlsMode describes how the HTTPS server obtains its certificates.
+func tlsMode(cfg *config.Config) string {
+	switch {
+	case cfg.SslCertPath != "" && len(cfg.Certificates) > 0:
+		return fmt.Sprintf("static certificate %s and %d SNI certificate(s)", cfg.SslCertPath, len(cfg.Certificates))
+
+	case cfg.SslCertPath != "":
+		return fmt.Sprintf("static certificate %s", cfg.SslCertPath)
+
+	case len(cfg.Certificates) > 0:
+		return fmt.Sprintf("%d SNI certificate(s)", len(cfg.Certificates))
+
+	case config.UsesLetsencrypt(cfg):
+		hosts := strings.Join(append([]string{cfg.Domain}, cfg.LetsencryptHosts...), ", ")
+		if cfg.LetsencryptStaging {
+			return fmt.Sprintf("Let's encrypt (staging) for %s in %s", hosts, acmecache.Describe(cfg))
+		}
+		return fmt.Sprintf("Let's encrypt for %s in %s", hosts, acmecache.Describe(cfg))
+
+	default:
+		return "none (HTTP only)"
+	}
+}
+
+// printSummary prints a human-readable summary of the TLS mode, the auths and the routes of the config.
+func printSummary(w io.Writer, cfg *config.Config) {
+	fmt.Fprintf(w, "TLS: %s\n", tlsMode(cfg))
+
+	authIDs := make([]string, 0, len(cfg.Auths))
+	for id := range cfg.Auths {
+		authIDs = append(authIDs, id)
+	}
+	sort.Strings(authIDs)
+
+	fmt.Fprintf(w, "\nAuths (%d):\n", len(authIDs))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "  ID\tTYPE\tUSERNAME")
+	for _, id := range authIDs {
+		a := cfg.Auths[id]
+
+		authType := a.Type
+		if authType == "" {
+			authType = config.AuthTypeBasic
+		}
+
+		username := a.Username
+		if username == "" {
+			username = "-"
+		}
+
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", id, authType, username)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nRoutes (%d):\n", len(cfg.Routes))
+	writeRouteTable(w, cfg.Routes)
+
+	if cfg.DefaultTarget != "" {
+		fmt.Fprintf(w, "\nDefault target: %s\n", cfg.DefaultTarget)
+	}
+}
+
+// writeRouteTable writes the prefixes, the targets and the auth IDs of the routes as a table.
+func writeRouteTable(w io.Writer, routes []config.Route) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "  PREFIX\tTARGET(S)\tAUTHS")
+	for _, route := range routes {
+		prefix := route.Prefix
+		if route.Match != "" {
+			prefix = fmt.Sprintf("%s (match %s)", prefix, route.Match)
+		}
+
+		auths := strings.Join(route.AuthIDs, ", ")
+		switch {
+		case route.Public:
+			auths = "public"
+		case auths == "":
+			auths = "-"
+		}
+
+		target := strings.Join(route.AllTargets(), ", ")
+		if route.RedirectTo != "" {
+			target = "redirect to " + route.RedirectTo
+		}
+
+		if route.ForwardProxy != nil {
+			prefix = "(CONNECT)"
+			target = "tunnel to " + strings.Join(route.ForwardProxy.AllowedHosts, ", ")
+		}
+
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", prefix, target, auths)
+	}
+	tw.Flush()
+}
+
+// logRouteTable logs the TLS mode and the route table, one line per route.
+func logRouteTable(cfg *config.Config, logOut *log.Logger) {
+	logOut.Printf("TLS: %s\n", tlsMode(cfg))
+
+	var b strings.Builder
+	writeRouteTable(&b, cfg.Routes)
+
+	if cfg.DefaultTarget != "" {
+		logOut.Printf("Default target: %s\n", cfg.DefaultTarget)
+	}
+
+	logOut.Printf("Serving %d route(s):\n", len(cfg.Routes))
+	for _, line := range strings.Split(strings.TrimRight(b.String(), "\n"), "\n") {
+		logOut.Println(line)
+	}
+}
+
+type args struct {
+	revproxyPath     *string
+	quiet            *bool
+	waitForUpstreams *time.Duration
+	watch            *bool
+	checkConfig      *bool
+}
+
+// rewritePath rewrites the path of the request if it matches the regular expression.
+//
+// If the replaced path contains a query, the query of the request is replaced as well. Otherwise,
+// the query is preserved.
+func rewritePath(req *http.Request, re *regexp.Regexp, replacement string) {
+	pth := req.URL.Path
+	if !strings.HasPrefix(pth, "/") {
+		pth = "/" + pth
+	}
+
+	if !re.MatchString(pth) {
+		return
+	}
+
+	rewritten := re.ReplaceAllString(pth, replacement)
+
+	if i := strings.Index(rewritten, "?"); i >= 0 {
+		req.URL.RawQuery = rewritten[i+1:]
+		rewritten = rewritten[:i]
+	}
+
+	req.URL.Path = rewritten
+	req.URL.RawPath = ""
+}
+
+// applyHeaders sets the given headers. The headers with empty values are removed.
+func applyHeaders(header http.Header, headers map[string]string) {
+	for key, value := range headers {
+		if value == "" {
+			header.Del(key)
+		} else {
+			header.Set(key, value)
+		}
+	}
+}
+
+// loadClientCAs loads the CA certificates against which the TLS client certificates are verified.
+func loadClientCAs(pth string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the client CA certificates %s: %s", pth, err.Error())
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no client CA certificates found in: %s", pth)
+	}
+
+	return pool, nil
+}
+
+// newUpstreamTLSConfig creates the TLS config of the connections to the upstreams.
+func newUpstreamTLSConfig(upstreamTLS *config.UpstreamTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: upstreamTLS.InsecureSkipVerify}
+
+	if upstreamTLS.CAPath != "" {
+		pem, err := ioutil.ReadFile(upstreamTLS.CAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA certificates %s: %s", upstreamTLS.CAPath, err.Error())
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certificates found in: %s", upstreamTLS.CAPath)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if upstreamTLS.CertPath != "" {
+		cert, err := tls.LoadX509KeyPair(upstreamTLS.CertPath, upstreamTLS.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate %s and key %s: %s",
+				upstreamTLS.CertPath, upstreamTLS.KeyPath, err.Error())
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// intOr returns the value if positive and the fallback otherwise.
+func intOr(value int, fallback int) int {
+	if value > 0 {
+		return value
+	}
+	return fallback
+}
+
+// newTransport creates the transport to the upstreams of the route with the timeouts and the TLS settings
+// of the route. The pool of the idle connections is sized by the route, falling back to the config.
+func newTransport(route config.Route, cfg *config.Config) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if n := intOr(route.MaxIdleConns, cfg.MaxIdleConns); n > 0 {
+		transport.MaxIdleConns = n
+	}
+
+	if n := intOr(route.MaxIdleConnsPerHost, cfg.MaxIdleConnsPerHost); n > 0 {
+		transport.MaxIdleConnsPerHost = n
+	}
+
+	if n := intOr(route.IdleConnTimeoutSeconds, cfg.IdleConnTimeoutSeconds); n > 0 {
+		transport.IdleConnTimeout = time.Duration(n) * time.Second
+	}
+
+	if route.UpstreamTLS != nil {
+		tlsConfig, err := newUpstreamTLSConfig(route.UpstreamTLS)
+		if err != nil {
+			return nil, err
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if route.DialTimeoutSeconds > 0 {
+		dialer := &net.Dialer{
+			Timeout:   time.Duration(route.DialTimeoutSeconds) * time.Second,
+			KeepAlive: 30 * time.Second}
+
+		transport.DialContext = dialer.DialContext
+	}
+
+	if route.ResponseHeaderTimeoutSeconds > 0 {
+		transport.ResponseHeaderTimeout = time.Duration(route.ResponseHeaderTimeoutSeconds) * time.Second
+	}
+
+	return transport, nil
+}
+
+// newProxy creates a reverse proxy to the target URL of the route.
+//
+// If stripClientCert is set, the client certificate headers supplied by the clients are removed so that they
+// can not be spoofed towards an upstream which trusts them on another route forwarding the certificates.
+func newProxy(target *url.URL, route config.Route, domain string, stripClientCert bool,
+	transport http.RoundTripper) (*httputil.ReverseProxy, error) {
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = transport
+
+	// The proxy sends the Host of the client unless the route specifies otherwise.
+	switch {
+	case route.UpstreamHost != "":
+		director := proxy.Director
+		upstreamHost := route.UpstreamHost
+
+		proxy.Director = func(req *http.Request) {
+			director(req)
+			req.Host = upstreamHost
+		}
+
+	case route.PreserveHost != nil && !*route.PreserveHost:
+		director := proxy.Director
+
+		proxy.Director = func(req *http.Request) {
+			director(req)
+			req.Host = target.Host
+		}
+	}
+
+	if route.Rewrite != nil {
+		re, err := regexp.Compile(route.Rewrite.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile the rewrite pattern: %s", err.Error())
+		}
+
+		director := proxy.Director
+		replacement := route.Rewrite.Replacement
+
+		proxy.Director = func(req *http.Request) {
+			rewritePath(req, re, replacement)
+			director(req)
+		}
+	}
+
+	var modifiers []func(resp *http.Response)
+
+	if route.RewriteCookies {
+		prefix := route.Prefix
+		if route.StripPrefix != nil && !*route.StripPrefix {
+			// The upstream sees the full path, so its cookie paths need no prefix.
+			prefix = ""
+		}
+
+		modifiers = append(modifiers, func(resp *http.Response) {
+			setcookie.RewriteResponse(resp, domain, prefix)
+		})
+	}
+
+	if len(route.ResponseHeaders) > 0 {
+		responseHeaders := route.ResponseHeaders
+
+		modifiers = append(modifiers, func(resp *http.Response) {
+			applyHeaders(resp.Header, responseHeaders)
+		})
+	}
+
+	if len(modifiers) > 0 {
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			for _, modify := range modifiers {
+				modify(resp)
+			}
+			return nil
+		}
+	}
+
+	if stripClientCert && !route.ForwardClientCert {
+		director := proxy.Director
+
+		// The headers are stripped before the request headers of the route are applied.
+		proxy.Director = func(req *http.Request) {
+			director(req)
+			clientcert.Strip(req)
+		}
+	}
+
+	if len(route.RequestHeaders) > 0 {
+		director := proxy.Director
+		requestHeaders := route.RequestHeaders
+
+		proxy.Director = func(req *http.Request) {
+			director(req)
+			applyHeaders(req.Header, requestHeaders)
+		}
+	}
+
+	if route.ForwardClientCert {
+		director := proxy.Director
+		withFields := route.ForwardClientCertFields
+
+		proxy.Director = func(req *http.Request) {
+			director(req)
+			clientcert.Forward(req, withFields)
+		}
+	}
+
+	return proxy, nil
+}
+
+// cleanPath returns the canonical form of the URL path, eliminating "." and ".." elements and repeated slashes,
+// while keeping the trailing slash.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+
+	if p[0] != '/' {
+		p = "/" + p
+	}
+
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	return cleaned
+}
+
+// prefixRoute represents a route selected by the prefix of the request path.
+type prefixRoute struct {
+	prefix string
+
+	// strip indicates whether the prefix is stripped from the path before the request is passed on.
+	strip bool
+
+	handler http.Handler
+}
+
+// matchPrefix returns the number of the characters of the path matched by the prefix, or -1 if it does not match.
+//
+// The prefix matches the path if the path equals it or continues it at a slash: "/api" matches "/api" and
+// "/api/foo", but not "/apix". A prefix with a trailing slash also matches the path without it: "/api/"
+// matches "/api".
+func matchPrefix(prefix string, p string) int {
+	switch {
+	case p == prefix:
+		return len(p)
+
+	case strings.HasSuffix(prefix, "/") && p == strings.TrimSuffix(prefix, "/"):
+		return len(p)
+
+	case strings.HasPrefix(p, prefix) && (strings.HasSuffix(prefix, "/") || p[len(prefix)] == '/'):
+		return len(prefix)
+
+	default:
+		return -1
+	}
+}
+
+// prefixRouter dispatches the requests to the route with the longest matching prefix and strips the prefix
+// from the path unless disabled for the route. If a path is matched equally long by "/api" and "/api/", the former is selected.
+//
+// Unlike http.ServeMux, a prefix without a trailing slash also matches the subtree, and a prefix with
+// a trailing slash matches the path without it instead of redirecting. The paths which are not clean are
+// redirected to their clean form as by http.ServeMux so that "." and ".." can not be used to escape a route.
+type prefixRouter struct {
+	routes   []prefixRoute
+	fallback http.Handler
+}
+
+func (pr *prefixRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodConnect {
+		if cleaned := cleanPath(req.URL.Path); cleaned != req.URL.Path {
+			u := *req.URL
+			u.Path = cleaned
+			u.RawPath = ""
+			http.Redirect(w, req, u.String(), http.StatusMovedPermanently)
+			return
+		}
+	}
+
+	var best *prefixRoute
+	bestLen := -1
+	for i := range pr.routes {
+		route := &pr.routes[i]
+
+		n := matchPrefix(route.prefix, req.URL.Path)
+		if n == -1 {
+			continue
+		}
+
+		if best == nil || n > bestLen || (n == bestLen && len(route.prefix) < len(best.prefix)) {
+			best, bestLen = route, n
+		}
+	}
+
+	if best == nil {
+		pr.fallback.ServeHTTP(w, req)
+		return
+	}
+
+	if !best.strip {
+		best.handler.ServeHTTP(w, req)
+		return
+	}
+
+	p, ok := stripPrefix(best.prefix, req.URL.Path)
+	if !ok {
+		pr.fallback.ServeHTTP(w, req)
+		return
+	}
+
+	rp := ""
+	if req.URL.RawPath != "" {
+		rp, ok = stripPrefix(best.prefix, req.URL.RawPath)
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+	}
+
+	best.handler.ServeHTTP(w, withPath(req, p, rp))
+}
+
+// withPath shallow-copies the request as by http.StripPrefix and sets the path of the copy.
+func withPath(req *http.Request, p string, rp string) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.URL = new(url.URL)
+	*r.URL = *req.URL
+	r.URL.Path = p
+	r.URL.RawPath = rp
+
+	return r
+}
+
+// connectRouter dispatches the CONNECT requests to the forward proxy and all the others to the routes.
+type connectRouter struct {
+	connect http.Handler
+	handler http.Handler
+}
+
+func (cr *connectRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodConnect {
+		cr.connect.ServeHTTP(w, req)
+		return
+	}
+
+	cr.handler.ServeHTTP(w, req)
+}
+
+// stripPrefix strips the prefix from the path. The prefix with a trailing slash is stripped from the path equal
+// to the prefix without it as well.
+func stripPrefix(prefix string, p string) (string, bool) {
+	switch {
+	case strings.HasPrefix(p, prefix):
+		return p[len(prefix):], true
+
+	case strings.HasSuffix(prefix, "/") && p == strings.TrimSuffix(prefix, "/"):
+		return "", true
+
+	default:
+		return "", false
+	}
+}
+
+// redirectHandler redirects the requests to the target URL, appending the remainder of the path and the query.
+type redirectHandler struct {
+	target *url.URL
+	status int
+}
+
+// newRedirectHandler creates a redirect handler for the target URL. If the status is 0, 301 is used.
+func newRedirectHandler(target string, status int) (*redirectHandler, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == 0 {
+		status = http.StatusMovedPermanently
+	}
+
+	return &redirectHandler{target: u, status: status}, nil
+}
+
+func (h *redirectHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	u := *h.target
+
+	if rest := req.URL.Path; rest != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(rest, "/")
+		u.RawPath = ""
+	}
+
+	if req.URL.RawQuery != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += req.URL.RawQuery
+	}
+
+	http.Redirect(w, req, u.String(), h.status)
+}
+
+// regexRoute represents a route selected by a regular expression on the request path.
+type regexRoute struct {
+	re *regexp.Regexp
+
+	// prefix is stripped from the matched paths which start with it, unless strip is unset.
+	prefix string
+	strip  bool
+
+	handler http.Handler
+}
+
+// serve strips the prefix from the path if it starts with the prefix and passes on the request.
+// The paths matched outside of the prefix are passed on unchanged.
+func (route *regexRoute) serve(w http.ResponseWriter, req *http.Request) {
+	if !route.strip || route.prefix == "" || matchPrefix(route.prefix, req.URL.Path) == -1 {
+		route.handler.ServeHTTP(w, req)
+		return
+	}
+
+	p, _ := stripPrefix(route.prefix, req.URL.Path)
+
+	rp := ""
+	if req.URL.RawPath != "" {
+		var ok bool
+		rp, ok = stripPrefix(route.prefix, req.URL.RawPath)
+		if !ok {
+			// The prefix is escaped differently in the raw path, as handled by prefixRouter.
+			http.NotFound(w, req)
+			return
+		}
+	}
+
+	route.handler.ServeHTTP(w, withPath(req, p, rp))
+}
+
+// regexRouter dispatches the requests to the first matching regex route and falls back to the prefix router.
+type regexRouter struct {
+	routes   []regexRoute
+	fallback http.Handler
+}
+
+func (rr *regexRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Paths which are not clean are left to the fallback so that they are redirected to the clean paths first.
+	if cleanPath(req.URL.Path) == req.URL.Path {
+		for i := range rr.routes {
+			route := &rr.routes[i]
+			if route.re.MatchString(req.URL.Path) {
+				route.serve(w, req)
+				return
+			}
+		}
+	}
+
+	rr.fallback.ServeHTTP(w, req)
+}
+
+// routerState holds the state of a router which is carried over to the router of the reloaded config
+// so that a reload does not lift the limits of the clients.
+type routerState struct {
+	failures    *ratelimit.Failures // nil if the failed authentications are not limited
+	failuresKey string              // settings of the failures
+
+	// limiters are the rate limiters keyed by the route and its rate limit.
+	limiters map[string]*ratelimit.Limiter
+}
+
+// limiter returns the rate limiter stored under the key, or nil if there is none or the state is nil.
+func (s *routerState) limiter(key string) *ratelimit.Limiter {
+	if s == nil {
+		return nil
+	}
+
+	return s.limiters[key]
+}
+
+// setupRouter sets up the router for the routes of the config.
+//
+// The active health probes of the routes are spawned in the background, tracked by the wait group and
+// stopped once the context is done.
+//
+// If the metrics registry is given, the handled requests are observed in it.
+//
+// The rate limiters and the failed authentications of the previous router, if given, are carried over unless
+// their settings changed. The returned state is meant to be passed on to the router of the next reload.
+func setupRouter(ctx context.Context, wg *sync.WaitGroup, cfg *config.Config, reg *metrics.Registry,
+	prev *routerState, logOut *log.Logger, logErr *log.Logger) (http.Handler, *routerState, error) {
+
+	var prefixRoutes []prefixRoute
+
+	var regexRoutes []regexRoute
+
+	state := &routerState{limiters: make(map[string]*ratelimit.Limiter)}
+
+	// The TRACE requests are blocked per route so that the Allow header can list the methods of the route.
+	blockTrace := cfg.BlockTrace == nil || *cfg.BlockTrace
+
+	// failures is shared among the routes so that the clients can not spread the attempts over them.
+	var failures *ratelimit.Failures
+	if cfg.AuthMaxFailures > 0 {
+		window := secondsOr(cfg.AuthFailureWindowSeconds, time.Minute)
+		cooldown := secondsOr(cfg.AuthCooldownSeconds, window)
+
+		state.failuresKey = fmt.Sprintf("%d %s %s", cfg.AuthMaxFailures, window, cooldown)
+		if prev != nil && prev.failures != nil && prev.failuresKey == state.failuresKey {
+			state.failures = prev.failures
+		} else {
+			state.failures = ratelimit.NewFailures(cfg.AuthMaxFailures, window, cooldown)
+		}
+
+		failures = state.failures
+		failures.StartEvicting(ctx, wg, rateLimitEvictionInterval)
+	}
+
+	pages, err := loadErrorPages(cfg.ErrorPages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	handledRoot := false
+
+	// connectHandler handles the CONNECT requests if a route is a forward proxy.
+	var connectHandler http.Handler
+
+	hasRootRoute := false
+	for _, route := range cfg.Routes {
+		if route.Match == "" && route.Prefix == "/" {
+			hasRootRoute = true
+		}
+	}
+
+	routes := cfg.Routes
+	if cfg.DefaultTarget != "" && !hasRootRoute {
+		// The default target handles the requests matched by no other route as a public root route.
+		routes = append(routes[:len(routes):len(routes)],
+			config.Route{Prefix: "/", Target: cfg.DefaultTarget, Public: true})
+	}
+
+	for _, route := range routes {
+		targets := route.AllTargets()
+		if len(targets) == 0 && route.RedirectTo == "" && route.ForwardProxy == nil {
+			return nil, nil, fmt.Errorf("no target for the Route: %s", route.Prefix)
+		}
+
+		var handler http.Handler
+		var source string
+
+		encodedSlashes := route.EncodedSlashes
+
+		bodyLimit := route.MaxRequestBodyBytes
+		if bodyLimit == 0 {
+			bodyLimit = cfg.MaxRequestBodyBytes
+		}
+
+		switch {
+		case route.ForwardProxy != nil:
+			handler = forwardproxy.New(route.ForwardProxy.AllowedHosts, route.ForwardProxy.AllowedPorts,
+				secondsOr(route.DialTimeoutSeconds, 30*time.Second), logErr)
+			source = sourceTunnel
+			targets = route.ForwardProxy.AllowedHosts
+
+			// The CONNECT requests have no path.
+			encodedSlashes = config.EncodedSlashesRaw
+
+		case route.RedirectTo != "":
+			handler, err = newRedirectHandler(route.RedirectTo, route.RedirectStatus)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to set up the redirect of the route %s: %s",
+					route.Prefix, err.Error())
+			}
+			source = sourceRedirect
+			targets = []string{route.RedirectTo}
+
+			if encodedSlashes == "" {
+				encodedSlashes = config.EncodedSlashesRaw
+			}
+
+		case len(targets) == 1 && strings.HasPrefix(targets[0], "/"):
+			var err error
+			handler, err = newFileServer(http.Dir(targets[0]), route, pages, logErr)
+			if err != nil {
+				return nil, nil, err
+			}
+			source = sourceFile
+
+			if encodedSlashes == "" {
+				encodedSlashes = config.EncodedSlashesReject
+			}
+
+		default:
+			var backends []*balancer.Backend
+			transport, err := newTransport(route, cfg)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to set up the transport of the route %s: %s",
+					route.Prefix, err.Error())
+			}
+
+			// A single backend retries on the transport. The balancer retries on the next backend instead.
+			balanced := len(targets) > 1 || route.ActiveHealth != nil
+
+			var proxyTransport http.RoundTripper = transport
+			if route.Retries > 0 && !balanced {
+				proxyTransport = &retry.Transport{Transport: transport, Retries: route.Retries}
+			}
+
+			// The circuits are kept per backend. The cached responses are served even if the circuit is open.
+			if route.CircuitBreaker != nil {
+				proxyTransport = circuitbreaker.New(proxyTransport, route.CircuitBreaker.FailureThreshold,
+					time.Duration(route.CircuitBreaker.OpenSeconds)*time.Second, route.Prefix, logOut, logErr)
+			}
+
+			// The backends of the route share the cache.
+			if route.Cache != nil {
+				proxyTransport = respcache.New(proxyTransport,
+					route.Cache.MaxEntries, route.Cache.MaxEntryBytes, route.Cache.AllowAuthenticated,
+					time.Duration(route.Cache.StaleIfErrorSeconds)*time.Second)
+			}
+
+			for _, target := range targets {
+				parsedURL, err := url.ParseRequestURI(target)
+				if err != nil {
+					return nil, nil, fmt.Errorf("does not know how to handle the Route: %s", target)
+				}
+
+				proxy, err := newProxy(parsedURL, route, cfg.Domain, cfg.ClientCAPath != "", proxyTransport)
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to set up the proxy for the route %s: %s",
+						route.Prefix, err.Error())
+				}
+
+				if _, ok := pages[http.StatusBadGateway]; ok {
+					target := target
+
+					proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+						logErr.Printf("Failed to proxy the request to %s: %s\n", target, err.Error())
+						pages.serve(w, "Bad gateway", http.StatusBadGateway)
+					}
+				}
+
+				if bodyLimit > 0 {
+					proxy.ErrorHandler = newBodyLimitErrorHandler(proxy.ErrorHandler, logErr)
+				}
+
+				if route.CircuitBreaker != nil {
+					proxy.ErrorHandler = newCircuitOpenErrorHandler(proxy.ErrorHandler, pages, logErr)
+				}
+
+				backends = append(backends, &balancer.Backend{Target: target, Proxy: proxy})
+			}
+
+			if !balanced {
+				handler = backends[0].Proxy
+			} else {
+				b := balancer.New(backends, route.HealthCheck, route.Retries, route.Prefix, logOut, logErr)
+
+				if route.ActiveHealth != nil {
+					b.StartProbing(ctx, wg, route.ActiveHealth, transport)
+				}
+
+				handler = b
+			}
+			source = sourceProxy
+
+			if route.Cache != nil {
+				handler = &respcache.Handler{Handler: handler}
+			}
+
+			if encodedSlashes == "" {
+				encodedSlashes = config.EncodedSlashesRaw
+			}
+		}
+
+		if route.Compression {
+			handler = &compression.Handler{Handler: handler}
+		}
+
+		sampleRate := 1.0
+		if route.LogSampleRate != nil {
+			sampleRate = *route.LogSampleRate
+		}
+
+		handler = &loggingHandler{
+			logOut:     logOut,
+			logErr:     logErr,
+			prefix:     route.Prefix,
+			target:     strings.Join(targets, ","),
+			source:     source,
+			sampleRate: sampleRate,
+			logHeaders: route.LogHeaders,
+			handler:    handler}
+
+		if !route.Public {
+			authMap := make(map[string]*config.Auth)
+			for _, authID := range route.AuthIDs {
+				authMap[authID] = cfg.Auths[authID]
+			}
+
+			auths, err := auth.New(authMap)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if !auths.All {
+				realm := route.Realm
+				if realm == "" {
+					realm = cfg.Realm
+				}
+				if realm == "" {
+					realm = defaultRealm
+				}
+
+				handler = &authHandler{
+					auths:    auths,
+					realm:    realm,
+					proxy:    route.ForwardProxy != nil,
+					failures: failures,
+					logErr:   logErr,
+					handler:  handler}
+			}
+		}
+
+		if route.AccessSchedule != nil {
+			sched, err := schedule.New(route.AccessSchedule)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse the access schedule of the route %s: %s",
+					route.Prefix, err.Error())
+			}
+
+			handler = &scheduleHandler{
+				schedule: sched,
+				logErr:   logErr,
+				handler:  handler}
+		}
+
+		if route.RateLimit != nil {
+			key := fmt.Sprintf("%s %s %g %d", route.Prefix, route.Match,
+				route.RateLimit.RequestsPerSecond, route.RateLimit.Burst)
+
+			limiter := prev.limiter(key)
+			if limiter == nil || state.limiters[key] != nil {
+				limiter = ratelimit.New(route.RateLimit.RequestsPerSecond, route.RateLimit.Burst)
+			}
+			state.limiters[key] = limiter
+
+			limiter.StartEvicting(ctx, wg, rateLimitEvictionInterval)
+
+			handler = &rateLimitHandler{
+				limiter: limiter,
+				logErr:  logErr,
+				handler: handler}
+		}
+
+		if bodyLimit > 0 {
+			handler = &bodyLimitHandler{limit: bodyLimit, logErr: logErr, handler: handler}
+		}
+
+		if len(route.Methods) > 0 {
+			handler = newMethodHandler(route.Methods, logErr, handler)
+		}
+
+		securityHeaders := route.SecurityHeaders
+		if securityHeaders == nil {
+			securityHeaders = cfg.SecurityHeaders
+		}
+		if securityHeaders != nil {
+			handler = newSecurityHeadersHandler(securityHeaders, handler)
+		}
+
+		handler = &encodedSlashHandler{
+			mode:    encodedSlashes,
+			logErr:  logErr,
+			handler: handler}
+
+		if route.DisableWriteTimeout {
+			handler = &noWriteTimeoutHandler{logErr: logErr, handler: handler}
+		}
+
+		if blockTrace {
+			handler = newTraceBlockHandler(route.Methods, logErr, handler)
+		}
+
+		if reg != nil {
+			handler = &metricsHandler{metrics: reg, prefix: route.Prefix, handler: handler}
+		}
+
+		if route.ForwardProxy != nil {
+			connectHandler = handler
+			continue
+		}
+
+		strip := route.StripPrefix == nil || *route.StripPrefix
+
+		if route.Match != "" {
+			re, err := regexp.Compile(route.Match)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to compile the match of the route %s: %s",
+					route.Prefix, err.Error())
+			}
+
+			regexRoutes = append(regexRoutes, regexRoute{re: re, prefix: route.Prefix, strip: strip, handler: handler})
+			continue
+		}
+
+		prefixRoutes = append(prefixRoutes, prefixRoute{prefix: route.Prefix, strip: strip, handler: handler})
+
+		if route.Prefix == "/" {
+			handledRoot = true
+		}
+	}
+
+	var notFound http.Handler = http.NotFoundHandler()
+	if !handledRoot {
+		notFound = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			msg := newMessage(req)
+			msg.Error = "not found"
+			msg.Source = sourceError
+			msg.StatusCode = http.StatusNotFound
+
+			bb, err := msg.encode()
+			if err != nil {
+				http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
+				logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
+				return
+			}
+
+			logErr.Printf("%s\n", string(bb))
+
+			pages.serve(w, "Not found", http.StatusNotFound)
+			return
+		})
+
+		if reg != nil {
+			// The requests matched by no route are observed without a prefix.
+			notFound = &metricsHandler{metrics: reg, prefix: "", handler: notFound}
+		}
+	}
+
+	if blockTrace {
+		notFound = newTraceBlockHandler(nil, logErr, notFound)
+	}
+
+	var handler http.Handler = &prefixRouter{routes: prefixRoutes, fallback: notFound}
+	if len(regexRoutes) > 0 {
+		handler = &regexRouter{routes: regexRoutes, fallback: handler}
+	}
+
+	if connectHandler != nil {
+		handler = &connectRouter{connect: connectHandler, handler: handler}
+	}
+
+	requestIDHeader := cfg.RequestIDHeader
+	if requestIDHeader == "" {
+		requestIDHeader = defaultRequestIDHeader
+	}
+
+	return &requestIDHandler{header: http.CanonicalHeaderKey(requestIDHeader), handler: handler}, state, nil
+}
+
+// redirectionURL constructs the HTTPS URL corresponding to the request on the HTTP server.
+//
+// The host is taken from the request unless the HTTPS address specifies one. The port is taken from the HTTPS
+// address and omitted if it is the standard 443. The path and the query of the request are preserved as-is.
+func redirectionURL(httpsAddr string, req *http.Request) string {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(req.Host); err == nil {
+		// Discard the port of the HTTP server.
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	_, unix := config.UnixSocketPath(httpsAddr)
+
+	addrHost, port, err := net.SplitHostPort(httpsAddr)
+	switch {
+	case unix:
+		// The HTTPS server listens on a Unix domain socket behind a frontend serving the standard port.
+		addrHost, port = "", ""
+
+	case err != nil:
+		// The address specifies no port.
+		addrHost, port = httpsAddr, ""
+	}
+
+	if ip := net.ParseIP(addrHost); addrHost != "" && (ip == nil || !ip.IsUnspecified()) {
+		host = addrHost
+	}
+
+	if port != "" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// Bracket the IPv6 address.
+		host = "[" + host + "]"
+	}
+
+	u := url.URL{
+		Scheme:   "https",
+		Host:     host,
+		Path:     req.URL.Path,
+		RawPath:  req.URL.RawPath,
+		RawQuery: req.URL.RawQuery}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return u.String()
+}
+
+// setupRedirectionRouter sets up the router of the HTTP server which redirects all the requests to HTTPS.
+//
+// If status is 0, the requests are redirected with http.StatusMovedPermanently. The address of the HTTPS server
+// is loaded on each request since the server moves to a new address on reload.
+func setupRedirectionRouter(httpsAddr *atomic.Value, status int, logOut *log.Logger,
+	logErr *log.Logger) (http.Handler, error) {
+	if status == 0 {
+		status = http.StatusMovedPermanently
+	}
+
+	router := http.NewServeMux()
+	router.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		newURL := redirectionURL(httpsAddr.Load().(string), req)
+
+		msg := newMessage(req)
+		msg.RedirectionURL = newURL
+		msg.Source = sourceRedirect
+		msg.StatusCode = status
+
+		bb, err := msg.encode()
+		if err != nil {
+			http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
+			logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
+			return
+		}
+
+		logOut.Printf("%s\n", string(bb))
+		http.Redirect(w, req, newURL, status)
+	})
+
+	return router, nil
+}
+
+// secondsOr converts the seconds to a duration, or returns the default duration if the seconds are zero.
+func secondsOr(seconds int, defaultDuration time.Duration) time.Duration {
+	if seconds == 0 {
+		return defaultDuration
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// setTimeouts sets the timeouts of the server as specified in the config.
+func setTimeouts(srv *http.Server, cfg *config.Config) {
+	srv.ReadHeaderTimeout = secondsOr(cfg.ReadHeaderTimeoutSeconds, 60*time.Second)
+	srv.ReadTimeout = secondsOr(cfg.ReadTimeoutSeconds, 60*time.Second)
+	srv.WriteTimeout = secondsOr(cfg.WriteTimeoutSeconds, 5*time.Minute)
+	srv.IdleTimeout = secondsOr(cfg.IdleTimeoutSeconds, 60*time.Second)
+}
+
+// reloadableRouter serves the requests with a router which can be swapped on a config reload.
+type reloadableRouter struct {
+	handler atomic.Value // holds the http.Handler of the active router
+
+	mu         sync.Mutex // serializes the reloads
+	configPath string
+	cfg        *config.Config
+	state      *routerState // state of the active router
+
+	// ctx is the parent context of the routers; cancel stops the active health probes of the active router.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     *sync.WaitGroup
+
+	metrics *metrics.Registry // nil if the metrics are not collected
+
+	// servers are moved to the new addresses on reload. They are set once the servers are set up.
+	servers []*rebindableServer
+
+	logOut *log.Logger
+	logErr *log.Logger
+}
+
+// newReloadableRouter sets up the router for the config loaded from the given path.
+//
+// The active health probes are tracked by the wait group and stopped once the context is done or
+// the router is replaced.
+func newReloadableRouter(ctx context.Context, wg *sync.WaitGroup, configPath string, cfg *config.Config,
+	reg *metrics.Registry, logOut *log.Logger, logErr *log.Logger) (*reloadableRouter, error) {
+
+	rr := &reloadableRouter{
+		configPath: configPath,
+		cfg:        cfg,
+		ctx:        ctx,
+		wg:         wg,
+		metrics:    reg,
+		logOut:     logOut,
+		logErr:     logErr}
+
+	routerCtx, cancel := context.WithCancel(ctx)
+
+	router, state, err := setupRouter(routerCtx, wg, cfg, reg, nil, logOut, logErr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	rr.handler.Store(router)
+	rr.state = state
+	rr.cancel = cancel
+
+	return rr, nil
+}
+
+func (rr *reloadableRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rr.handler.Load().(http.Handler).ServeHTTP(w, req)
+}
+
+// restartSettingsChanged checks whether the settings which are only applied on restart differ.
+func restartSettingsChanged(old *config.Config, cfg *config.Config) bool {
+	return old.Domain != cfg.Domain ||
+		old.SslCertPath != cfg.SslCertPath ||
+		old.SslKeyPath != cfg.SslKeyPath ||
+		old.SslKeyPassphrase != cfg.SslKeyPassphrase ||
+		old.LetsencryptDir != cfg.LetsencryptDir ||
+		old.LetsencryptStaging != cfg.LetsencryptStaging ||
+		old.LetsencryptEmail != cfg.LetsencryptEmail ||
+		!reflect.DeepEqual(old.LetsencryptHosts, cfg.LetsencryptHosts) ||
+		!reflect.DeepEqual(old.LetsencryptCache, cfg.LetsencryptCache) ||
+		!reflect.DeepEqual(old.Certificates, cfg.Certificates) ||
+		old.OCSPStapling != cfg.OCSPStapling ||
+		old.ClientCAPath != cfg.ClientCAPath ||
+		!reflect.DeepEqual(old.HSTS, cfg.HSTS) ||
+		old.DisableHttpsRedirect != cfg.DisableHttpsRedirect ||
+		old.RedirectStatus != cfg.RedirectStatus ||
+		(old.HTTP2 == nil || *old.HTTP2) != (cfg.HTTP2 == nil || *cfg.HTTP2) ||
+		old.ReadTimeoutSeconds != cfg.ReadTimeoutSeconds ||
+		old.ReadHeaderTimeoutSeconds != cfg.ReadHeaderTimeoutSeconds ||
+		old.WriteTimeoutSeconds != cfg.WriteTimeoutSeconds ||
+		old.IdleTimeoutSeconds != cfg.IdleTimeoutSeconds ||
+		old.HTTP2IdleTimeoutSeconds != cfg.HTTP2IdleTimeoutSeconds ||
+		old.ShutdownTimeoutSeconds != cfg.ShutdownTimeoutSeconds ||
+		old.ShutdownDelaySeconds != cfg.ShutdownDelaySeconds ||
+		old.MaxHeaderBytes != cfg.MaxHeaderBytes ||
+		old.StripServerHeaders != cfg.StripServerHeaders ||
+		old.MetricsAddress != cfg.MetricsAddress ||
+		old.HealthAddress != cfg.HealthAddress ||
+		strings.Join(old.TrustedProxies, ",") != strings.Join(cfg.TrustedProxies, ",") ||
+		old.MaxConcurrentRequests != cfg.MaxConcurrentRequests ||
+		old.ProxyProtocol != cfg.ProxyProtocol ||
+		old.AccessLogPath != cfg.AccessLogPath ||
+		old.ErrorLogPath != cfg.ErrorLogPath ||
+		old.LogMaxSizeMB != cfg.LogMaxSizeMB ||
+		old.LogFormat != cfg.LogFormat ||
+		old.LogTarget != cfg.LogTarget ||
+		old.SyslogFacility != cfg.SyslogFacility ||
+		old.SyslogTag != cfg.SyslogTag ||
+		!reflect.DeepEqual(old.LogPrefix, cfg.LogPrefix)
+}
+
+// Reload re-reads the config and swaps the router.
+//
+// If the config can not be loaded or the router can not be set up, the active router is kept.
+// The rate limits and the failed authentications of the clients are carried over to the new router.
+//
+// The servers are moved to the changed addresses. If a new address can not be bound, the server keeps its
+// address. The other changes to the listeners (SSL, timeouts, trusted proxies, concurrency limit and
+// PROXY protocol) and the log files are not applied and require a restart.
+func (rr *reloadableRouter) Reload() error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	cfg, err := config.Load(rr.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load the config from %s: %s", rr.configPath, err.Error())
+	}
+
+	routerCtx, cancel := context.WithCancel(rr.ctx)
+
+	router, state, err := setupRouter(routerCtx, rr.wg, cfg, rr.metrics, rr.state, rr.logOut, rr.logErr)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("failed to set up the router: %s", err.Error())
+	}
+
+	if restartSettingsChanged(rr.cfg, cfg) {
+		rr.logErr.Printf("The SSL settings, timeouts or log files in %s changed, " +
+			"but they are only applied on restart\n", rr.configPath)
+	}
+
+	for _, warning := range config.Warnings(cfg) {
+		rr.logErr.Printf("Warning: %s\n", warning)
+	}
+
+	rr.handler.Store(router)
+
+	rr.cancel()
+	rr.cancel = cancel
+	rr.cfg = cfg
+	rr.state = state
+
+	for _, srv := range rr.servers {
+		srv.Rebind(cfg)
+	}
+
+	return nil
+}
+
+// Watch polls the modification time and the size of the config file and reloads the config on change.
+//
+// If the reload fails (e.g., the file has been only partially written), the error is logged and the reload is
+// retried on the next poll. Watch returns when the context is done.
+func (rr *reloadableRouter) Watch(ctx context.Context, interval time.Duration) {
+	var lastModTime time.Time
+	var lastSize int64
+
+	stat, err := os.Stat(rr.configPath)
+	if err == nil {
+		lastModTime = stat.ModTime()
+		lastSize = stat.Size()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stat, err := os.Stat(rr.configPath)
+		if err != nil {
+			rr.logErr.Printf("Failed to stat the config file %s: %s\n", rr.configPath, err.Error())
+			continue
+		}
+
+		if stat.ModTime().Equal(lastModTime) && stat.Size() == lastSize {
+			continue
+		}
+
+		rr.logOut.Printf("The config file %s changed, reloading it\n", rr.configPath)
+
+		err = rr.Reload()
+		if err != nil {
+			rr.logErr.Printf("Failed to reload the config, keeping the previous one and retrying: %s\n",
+				err.Error())
+			continue
+		}
+
+		lastModTime = stat.ModTime()
+		lastSize = stat.Size()
+
+		rr.logOut.Println("Reloaded the config.")
+	}
+}
+
+// Stop stops the active health probes of the active router.
+func (rr *reloadableRouter) Stop() {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.cancel()
+}
+
+// setupHealthRouter sets up the router for the liveness (/healthz) and readiness (/readyz) probes.
+//
+// The readiness probe succeeds only once ready returns true.
+func setupHealthRouter(live func() bool, ready func() bool, logOut *log.Logger, logErr *log.Logger) http.Handler {
+	probe := func(check func() bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			msg := newMessage(req)
+			msg.Source = sourceHealth
+			msg.StatusCode = http.StatusOK
+			if !check() {
+				msg.StatusCode = http.StatusServiceUnavailable
+			}
+
+			bb, err := msg.encode()
+			if err != nil {
+				http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
+				logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
+				return
+			}
+
+			logOut.Printf("%s\n", string(bb))
+
+			if msg.StatusCode != http.StatusOK {
+				http.Error(w, "Not ready", msg.StatusCode)
+				return
+			}
+
+			w.Write([]byte("OK\n"))
+		}
+	}
+
+	router := http.NewServeMux()
+	router.Handle("/healthz", probe(live))
+	router.Handle("/readyz", probe(ready))
+
+	return router
+}
+
+// listen binds the address. If empty, the address defaults to ":https" if useTLS is set and to ":http" otherwise.
+func listen(addr string, useTLS bool) (net.Listener, error) {
+	if addr == "" {
+		addr = ":http"
+		if useTLS {
+			addr = ":https"
+		}
+	}
+
+	if pth, ok := config.UnixSocketPath(addr); ok {
+		// The socket file is removed when the listener is closed.
+		ln, err := net.Listen("unix", pth)
+		if err == nil {
+			return ln, nil
+		}
+
+		// A socket left over by an unclean exit would prevent binding. The socket is only considered stale if
+		// nobody accepts the connections on it so that a second instance does not steal the socket of the first.
+		stat, statErr := os.Lstat(pth)
+		if statErr != nil || stat.Mode()&os.ModeSocket == 0 {
+			return nil, err
+		}
+
+		conn, dialErr := net.Dial("unix", pth)
+		if dialErr == nil {
+			conn.Close()
+			return nil, err
+		}
+
+		if !errors.Is(dialErr, syscall.ECONNREFUSED) {
+			return nil, err
+		}
+
+		if rmErr := os.Remove(pth); rmErr != nil {
+			return nil, fmt.Errorf("failed to remove the stale socket %s: %s", pth, rmErr.Error())
+		}
+
+		return net.Listen("unix", pth)
+	}
+
+	return net.Listen("tcp", addr)
+}
+
+// rebindableServer serves the requests on the listener of its address and moves to a new address on reload.
+type rebindableServer struct {
+	srv  *http.Server
+	name string // name of the server in the log messages, e.g., "HTTP"
+
+	// useTLS is set if the requests are served over TLS. It can not be told from the server since net/http sets
+	// the TLS config of the HTTP/2 support once the server serves.
+	useTLS bool
+
+	// addr holds the address string of the bound listener. It is shared with the handlers which need to know
+	// the address (e.g., the redirection to the HTTPS server).
+	addr *atomic.Value
+
+	// address selects the address of the server from the config.
+	address func(cfg *config.Config) string
+
+	// proxyProtocol is set if the connections start with a PROXY protocol header conveying the client address.
+	proxyProtocol bool
+
+	mu sync.Mutex // guards ln
+	ln net.Listener
+
+	logOut *log.Logger
+	logErr *log.Logger
+}
+
+// ListenAndServe binds the address of the server, reports that it is bound and serves the requests.
+//
+// If the listener is given (e.g., inherited from systemd), it is used instead of binding the address.
+// Once the server moved to a new address, the requests are served on the new listener.
+func (rs *rebindableServer) ListenAndServe(ln net.Listener, bound func()) error {
+	if ln == nil {
+		var err error
+		ln, err = listen(rs.addr.Load().(string), rs.useTLS)
+		if err != nil {
+			return err
+		}
+	}
+
+	rs.mu.Lock()
+	rs.ln = ln
+	rs.mu.Unlock()
+
+	bound()
+
+	for {
+		var err error
+		served := ln
+		if rs.proxyProtocol {
+			served = proxyproto.NewListener(ln, rs.srv.ReadHeaderTimeout)
+		}
+
+		if rs.useTLS {
+			// The certificates are provided by the TLS config.
+			err = rs.srv.ServeTLS(served, "", "")
+		} else {
+			err = rs.srv.Serve(served)
+		}
+
+		rs.mu.Lock()
+		next := rs.ln
+		rs.mu.Unlock()
+
+		if err == http.ErrServerClosed {
+			// The listener bound by a concurrent move is not tracked by the server, so it is not closed on
+			// shutdown.
+			if next != ln {
+				next.Close()
+			}
+			return err
+		}
+
+		if next == ln {
+			return err
+		}
+
+		// The listener has been closed since the server moved to the new one.
+		ln = next
+	}
+}
+
+// Rebind moves the server to the address given in the config if it changed.
+//
+// The new address is bound before the old listener is closed so that a failure to bind keeps the server
+// on the old address. The connections accepted on the old listener are served until they are closed.
+func (rs *rebindableServer) Rebind(cfg *config.Config) {
+	addr := rs.address(cfg)
+	oldAddr := rs.addr.Load().(string)
+	if addr == oldAddr {
+		return
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.ln == nil {
+		rs.logErr.Printf("The %s server has not been bound yet, so it is not moved to the address %#v\n",
+			rs.name, addr)
+		return
+	}
+
+	ln, err := listen(addr, rs.useTLS)
+	if err != nil {
+		rs.logErr.Printf("Failed to bind the %s server to the new address %#v, keeping the address %#v: %s\n",
+			rs.name, addr, oldAddr, err.Error())
+		return
+	}
+
+	rs.logOut.Printf("Moving the %s server from the address %#v to the address %#v\n", rs.name, oldAddr, addr)
+
+	old := rs.ln
+	rs.ln = ln
+	rs.addr.Store(addr)
+
+	old.Close()
+}
+
+// certificateExpiryWarning is the time before the expiry of a certificate from which on a warning is logged on start.
+const certificateExpiryWarning = 30 * 24 * time.Hour
+
+// checkCertificate checks that the loaded certificate is currently valid and returns the parsed leaf.
+func checkCertificate(certFile string, cert *tls.Certificate, now time.Time) (*x509.Certificate, error) {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the certificate %s: %s", certFile, err.Error())
+	}
+
+	if now.Before(leaf.NotBefore) {
+		return nil, fmt.Errorf("the certificate %s is not valid before %s",
+			certFile, leaf.NotBefore.UTC().Format(time.RFC3339))
+	}
+
+	if now.After(leaf.NotAfter) {
+		return nil, fmt.Errorf("the certificate %s expired on %s",
+			certFile, leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	return leaf, nil
+}
+
+// certificateWatchInterval is the interval of polling the certificate and key files for changes.
+const certificateWatchInterval = 10 * time.Second
+
+// certificateReloader reloads the static certificates when their files change so that the renewed certificates
+// are served without a restart.
+type certificateReloader struct {
+	// reloaders of the certificate of ssl_cert_path, if any, followed by the ones of the certificates
+	reloaders []*tlscert.Reloader
+
+	// stapler staples the OCSP response to the certificate of ssl_cert_path, if enabled.
+	stapler *ocspstaple.Stapler
+
+	logOut *log.Logger
+	logErr *log.Logger
+}
+
+// check verifies the loaded certificate before it is served and warns if it expires soon.
+func (cr *certificateReloader) check(certFile string) func(cert *tls.Certificate) error {
+	return func(cert *tls.Certificate) error {
+		leaf, err := checkCertificate(certFile, cert, time.Now())
+		if err != nil {
+			return err
+		}
+
+		if remaining := time.Until(leaf.NotAfter); remaining < certificateExpiryWarning {
+			cr.logErr.Printf("Warning: the certificate %s expires in %d day(s) on %s\n",
+				certFile, int(remaining.Hours()/24), leaf.NotAfter.UTC().Format(time.RFC3339))
+		}
+
+		return nil
+	}
+}
+
+// Reload reloads the certificates whose files changed. If a certificate fails to load, the previous one is kept.
+func (cr *certificateReloader) Reload() {
+	for i, r := range cr.reloaders {
+		reloaded, err := r.Reload(cr.check(r.CertFile))
+		if err != nil {
+			cr.logErr.Printf("Failed to reload the certificate %s, keeping the previous one and retrying: %s\n",
+				r.CertFile, err.Error())
+			continue
+		}
+
+		if !reloaded {
+			continue
+		}
+
+		cr.logOut.Printf("Reloaded the certificate %s\n", r.CertFile)
+
+		if i == 0 && cr.stapler != nil {
+			err = cr.stapler.Update(r.Certificate())
+			if err != nil {
+				cr.logErr.Printf("Failed to update the OCSP stapling of the certificate %s: %s\n",
+					r.CertFile, err.Error())
+			}
+		}
+	}
+}
+
+// Watch polls the certificate and key files and reloads them on change until the context is done.
+func (cr *certificateReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cr.Reload()
+	}
+}
+
+// newHostPolicy allows the certificates for the given hosts. A wildcard such as "*.example.com" covers
+// a single label.
+func newHostPolicy(hosts []string) autocert.HostPolicy {
+	exact := make(map[string]bool)
+	wildcards := make(map[string]bool)
+
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if strings.HasPrefix(host, "*.") {
+			wildcards[host[1:]] = true
+		} else {
+			exact[host] = true
+		}
+	}
+
+	return func(ctx context.Context, host string) error {
+		name := strings.ToLower(strings.TrimSuffix(host, "."))
+		if exact[name] {
+			return nil
+		}
+
+		if i := strings.Index(name, "."); i > 0 && wildcards[name[i:]] {
+			return nil
+		}
+
+		return fmt.Errorf("acme/autocert: the host is not allowed: %#v", host)
+	}
+}
+
+// letsencryptStagingURL is the directory URL of the staging environment of Let's encrypt.
+const letsencryptStagingURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+// setupServers sets up the HTTP server and, if SSL is used, the HTTPS server.
+//
+// The httpsAddr holds the address of the HTTPS server to which the HTTP server redirects.
+func setupServers(
+	router http.Handler, cfg *config.Config, httpsAddr *atomic.Value,
+	logOut *log.Logger, logErr *log.Logger) (
+	httpd *http.Server, httpsd *http.Server, certs *certificateReloader, err error) {
+
+	if cfg.MaxConcurrentRequests > 0 {
+		router = &concurrencyHandler{
+			semaphore: make(chan struct{}, cfg.MaxConcurrentRequests),
+			logErr:    logErr,
+			handler:   router}
+	}
+
+	maxHeaderBytes := cfg.MaxHeaderBytes
+	if maxHeaderBytes == 0 {
+		maxHeaderBytes = http.DefaultMaxHeaderBytes
+	}
+
+	router = &headerLimitHandler{limit: maxHeaderBytes, logErr: logErr, handler: router}
 
-		case parsedURL != nil:
-			handler = httputil.NewSingleHostReverseProxy(parsedURL)
+	if cfg.StripServerHeaders {
+		router = &stripHeadersHandler{headers: strippedServerHeaders, handler: router}
+	}
 
-		default:
-			return nil, fmt.Errorf("does not know how to handle the Route: %s", route.Target)
+	var resolver *clientip.Resolver
+	if len(cfg.TrustedProxies) > 0 {
+		resolver, err = clientip.New(cfg.TrustedProxies)
+		if err != nil {
+			return
 		}
 
-		handler = &loggingHandler{
-			logOut:  logOut,
-			logErr:  logErr,
-			prefix:  route.Prefix,
-			target:  route.Target,
-			handler: handler}
+		router = &clientIPHandler{resolver: resolver, handler: router}
+	}
 
-		authMap := make(map[string]*config.Auth)
-		for _, authID := range route.AuthIDs {
-			authMap[authID] = cfg.Auths[authID]
+	if !config.UsesSSL(cfg) {
+		httpd = &http.Server{Handler: router}
+	} else {
+		// httpsRouter handles the requests on the HTTPS server.
+		httpsRouter := router
+		if cfg.HSTS != nil {
+			httpsRouter = newHSTSHandler(cfg.HSTS, router)
 		}
 
-		auths, err := auth.New(authMap)
-		if err != nil {
-			return nil, err
-		}
+		// rediRouter handles the requests on the HTTP server.
+		var rediRouter http.Handler
+		if cfg.DisableHttpsRedirect {
+			rediRouter = router
+		} else {
+			rediRouter, err = setupRedirectionRouter(httpsAddr, cfg.RedirectStatus, logOut, logErr)
+			if err != nil {
+				err = fmt.Errorf("failed to set up the redirection router: %s", err.Error())
+				return
+			}
 
-		if !auths.All {
-			handler = &authHandler{
-				auths:   auths,
-				logErr:  logErr,
-				handler: handler}
+			if resolver != nil {
+				rediRouter = &clientIPHandler{resolver: resolver, handler: rediRouter}
+			}
 		}
 
-		router.Handle(route.Prefix, http.StripPrefix(route.Prefix, handler))
+		switch {
+		case cfg.SslCertPath != "" || len(cfg.Certificates) > 0:
+			certs = &certificateReloader{logOut: logOut, logErr: logErr}
 
-		if route.Prefix == "/" {
-			handledRoot = true
-		}
-	}
+			// defaultCert serves the certificate of ssl_cert_path, if any.
+			var defaultCert *tlscert.Reloader
+			if cfg.SslCertPath != "" {
+				defaultCert = tlscert.NewReloader(cfg.SslCertPath, cfg.SslKeyPath, cfg.SslKeyPassphrase)
+				certs.reloaders = append(certs.reloaders, defaultCert)
+			}
 
-	if !handledRoot{
-		router.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
-			msg := newMessage(req)
-			msg.Error = "not found"
-			msg.StatusCode = http.StatusNotFound
+			sniCerts := make([]*tlscert.Reloader, 0, len(cfg.Certificates))
+			for _, c := range cfg.Certificates {
+				r := tlscert.NewReloader(c.CertPath, c.KeyPath, "")
+				sniCerts = append(sniCerts, r)
+				certs.reloaders = append(certs.reloaders, r)
+			}
 
-			bb, err := json.Marshal(&msg)
-			if err != nil {
-				http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
-				logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
-				return
+			// The certificates are checked upfront so that a broken TLS setup fails on start instead of
+			// on the first handshake.
+			for _, r := range certs.reloaders {
+				_, err = r.Reload(certs.check(r.CertFile))
+				if err != nil {
+					return
+				}
 			}
 
-			logErr.Printf("%s\n", string(bb))
+			var selector *sni.Selector
+			if len(cfg.Certificates) > 0 {
+				selector, err = sni.New(cfg.Certificates, sniCerts)
+				if err != nil {
+					return
+				}
+			}
 
-			http.Error(w, "Not found", http.StatusNotFound)
-			return
-		})
-	}
+			// The certificates are served through the callbacks so that the reloaded ones are picked up
+			// by the new handshakes.
+			var getDefault func(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+			if defaultCert != nil {
+				getDefault = defaultCert.GetCertificate
+			}
 
-	return router, nil
-}
+			// cancelStapling stops refreshing the OCSP response on shutdown.
+			var cancelStapling context.CancelFunc
 
-func setupRedirectionRouter(httpsAddr string, logOut *log.Logger, logErr *log.Logger) (http.Handler, error) {
-	router := http.NewServeMux()
-	router.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
-		var prefix string
-		if strings.HasPrefix(httpsAddr, ":") {
-			parts := strings.Split(req.Host, ":")
-			host := parts[0]
+			if cfg.OCSPStapling {
+				certs.stapler, err = ocspstaple.New(
+					cfg.SslCertPath, cfg.SslKeyPath, cfg.SslKeyPassphrase, logOut, logErr)
+				if err != nil {
+					return
+				}
 
-			prefix = fmt.Sprintf("https://%s%s", host, httpsAddr)
-		} else {
-			prefix = fmt.Sprintf("https://") + httpsAddr
-		}
+				var staplingCtx context.Context
+				staplingCtx, cancelStapling = context.WithCancel(context.Background())
 
-		newURL := prefix + req.RequestURI
+				err = certs.stapler.Start(staplingCtx)
+				if err != nil {
+					cancelStapling()
+					err = fmt.Errorf("failed to start the OCSP stapling: %s", err.Error())
+					return
+				}
 
-		msg := newMessage(req)
-		msg.RedirectionURL = newURL
-		msg.StatusCode = http.StatusMovedPermanently
+				// The certificate of ssl_cert_path is served with the stapled response.
+				getDefault = certs.stapler.GetCertificate
+			}
 
-		bb, err := json.Marshal(&msg)
-		if err != nil {
-			http.Error(w, "Failed to JSON-encode log message", http.StatusInternalServerError)
-			logErr.Printf("Failed to JSON-encode log message %#v: %s", msg, err.Error())
-			return
-		}
+			tlsConfig := &tls.Config{
+				NextProtos:     []string{"h2", "http/1.1"},
+				GetCertificate: getDefault}
 
-		logOut.Printf("%s\n", string(bb))
-		http.Redirect(w, req, newURL, http.StatusMovedPermanently)
-	})
+			if selector != nil {
+				tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+					cert, err := selector.GetCertificate(hello)
+					if cert != nil || err != nil || getDefault == nil {
+						return cert, err
+					}
 
-	return router, nil
-}
+					// The certificate of ssl_cert_path is the fallback of the SNI selection.
+					return getDefault(hello)
+				}
+			}
 
-func setupServers(
-	cfg *config.Config, logOut *log.Logger, logErr *log.Logger) (httpd *http.Server, httpsd *http.Server, err error) {
+			httpd = &http.Server{Handler: rediRouter}
+			httpsd = &http.Server{
+				TLSConfig: tlsConfig,
+				Handler:   httpsRouter}
 
-	// set up a router
-	router, err := setupRouter(cfg, logOut, logErr)
-	if err != nil {
-		err = fmt.Errorf("failed to set up the router: %s", err.Error())
-		return
-	}
+			if cancelStapling != nil {
+				httpsd.RegisterOnShutdown(cancelStapling)
+			}
 
-	if cfg.SslCertPath == "" && cfg.LetsencryptDir == "" {
-		httpd = &http.Server{Handler: router}
-	} else {
-		var rediRouter http.Handler
-		rediRouter, err = setupRedirectionRouter(cfg.HttpsAddress, logOut, logErr)
-		if err != nil {
-			err = fmt.Errorf("failed to set up the redirection router: %s", err.Error())
-			return
-		}
+		case config.UsesLetsencrypt(cfg):
+			directoryURL := acme.LetsEncryptURL
+			if cfg.LetsencryptStaging {
+				directoryURL = letsencryptStagingURL
+			}
 
-		switch {
-		case cfg.SslCertPath != "":
-			httpd = &http.Server{Handler: rediRouter}
-			httpsd = &http.Server{Handler: router}
-
-		case cfg.LetsencryptDir != "":
-			logOut.Printf("Setting up Let's encrypt to the directory: %#v\n", cfg.LetsencryptDir)
-			hostPolicy := func(ctx context.Context, host string) error {
-				allowedHost := cfg.Domain
-				if host == allowedHost {
-					return nil
-				}
-				return fmt.Errorf("acme/autocert: only %s host is allowed, got: %#v", allowedHost, host)
+			// The cache keeps the staging account and certificates apart from the production ones.
+			var cache autocert.Cache
+			cache, err = acmecache.New(cfg)
+			if err != nil {
+				err = fmt.Errorf("failed to set up the Let's encrypt cache: %s", err.Error())
+				return
 			}
 
+			logOut.Printf("Setting up Let's encrypt (%s) cached in: %s\n", directoryURL, acmecache.Describe(cfg))
+
 			mger := &autocert.Manager{
 				Prompt:     autocert.AcceptTOS,
-				HostPolicy: hostPolicy,
-				Cache:      autocert.DirCache(cfg.LetsencryptDir),
+				HostPolicy: newHostPolicy(append([]string{cfg.Domain}, cfg.LetsencryptHosts...)),
+				Cache:      cache,
+				Client:     &acme.Client{DirectoryURL: directoryURL},
+				Email:      cfg.LetsencryptEmail,
 			}
 
 			httpd = &http.Server{Handler: mger.HTTPHandler(rediRouter)}
 
+			// The TLS config of the manager negotiates h2 and http/1.1 as well as the ACME TLS-ALPN challenge.
 			httpsd = &http.Server{
-				TLSConfig: &tls.Config{GetCertificate: mger.GetCertificate},
-				Handler:   router}
+				TLSConfig: mger.TLSConfig(),
+				Handler:   httpsRouter}
 
 			if cfg.SslCertPath != "" {
 				err = fmt.Errorf("expected empty SSL cert path, but got: %#v", cfg.SslCertPath)
@@ -376,27 +3408,64 @@ func setupServers(
 	}
 
 	if httpsd != nil {
-		httpsd.Addr = cfg.HttpsAddress
-		httpsd.ReadHeaderTimeout = 60 * time.Second
-		httpsd.ReadTimeout = 60 * time.Second
-		httpsd.IdleTimeout = 60 * time.Second
+		setTimeouts(httpsd, cfg)
+
+		if cfg.ClientCAPath != "" {
+			httpsd.TLSConfig.ClientCAs, err = loadClientCAs(cfg.ClientCAPath)
+			if err != nil {
+				return
+			}
+
+			// The clients without a certificate are still served; the routes decide what to do with it.
+			httpsd.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+
+		if cfg.HTTP2 != nil && !*cfg.HTTP2 {
+			// A non-nil empty map disables HTTP/2 and drops h2 from the ALPN protocols.
+			httpsd.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		}
+	}
+
+	setTimeouts(httpd, cfg)
+
+	// net/http rejects the requests over its limit with 431 without logging them. The headers up to twice
+	// the limit are read so that the requests exceeding the limit are rejected and logged by headerLimitHandler.
+	httpd.MaxHeaderBytes = headerReadSlack * maxHeaderBytes
+	if httpsd != nil {
+		httpsd.MaxHeaderBytes = headerReadSlack * maxHeaderBytes
 	}
 
-	httpd.Addr = cfg.HttpAddress
-	httpd.ReadHeaderTimeout = 60 * time.Second
-	httpd.ReadTimeout = 60 * time.Second
-	httpd.IdleTimeout = 60 * time.Second
+	// net/http applies IdleTimeout to both protocols. The HTTP/2 server of x/net takes a distinct idle timeout,
+	// but it must be attached to the otherwise configured server.
+	if httpsd != nil && cfg.HTTP2IdleTimeoutSeconds != 0 {
+		err = http2.ConfigureServer(httpsd, &http2.Server{
+			IdleTimeout: time.Duration(cfg.HTTP2IdleTimeoutSeconds) * time.Second})
+		if err != nil {
+			err = fmt.Errorf("failed to configure HTTP/2 of the HTTPS server: %s", err.Error())
+			return
+		}
+	}
 
-	return httpd, httpsd, nil
+	return httpd, httpsd, certs, nil
 }
 
 func run() int {
 	var a args
 	a.revproxyPath = flag.String("config_path", "",
-		"Path to the file containing the JSON-encoded configuration")
+		"Path to the file containing the configuration in JSON (or YAML if the extension is .yaml or .yml)")
 
 	a.quiet = flag.Bool("quiet", false, "If set, outputs as little messages as possible")
 
+	a.waitForUpstreams = flag.Duration("wait_for_upstreams", 0,
+		"If set, waits up to the given duration for the proxied targets to become reachable before serving")
+
+	a.watch = flag.Bool("watch", false,
+		"If set, watches the config file and reloads it automatically when it changes")
+
+	a.checkConfig = flag.Bool("check-config", false,
+		"If set, loads and validates the config, prints its summary and exits without serving")
+	flag.BoolVar(a.checkConfig, "check_config", false, "Alias for -check-config")
+
 	version := flag.Bool("version", false,
 		"If set, outputs only the version to the standard output and exits immediately")
 
@@ -438,24 +3507,268 @@ func run() int {
 		return 1
 	}
 
-	httpd, httpsd, err := setupServers(revproxy, logOut, logErr)
+	if *a.checkConfig {
+		// The router is set up to catch the errors beyond the validation (e.g., the unreadable error pages or
+		// the malformed password hashes). Its background tasks are stopped right away.
+		checkCtx, cancelCheck := context.WithCancel(context.Background())
+		cancelCheck()
+
+		var checkWg sync.WaitGroup
+		_, _, err = setupRouter(checkCtx, &checkWg, revproxy, nil, nil, logOut, logErr)
+		checkWg.Wait()
+
+		if err != nil {
+			logErr.Printf("Failed to set up the router: %s\n", err.Error())
+			return 1
+		}
+
+		for _, warning := range config.Warnings(revproxy) {
+			logErr.Printf("Warning: %s\n", warning)
+		}
+
+		printSummary(os.Stdout, revproxy)
+		return 0
+	}
+
+	if revproxy.LogFormat != "" {
+		messageFormat = revproxy.LogFormat
+	}
+
+	bare := revproxy.LogPrefix != nil && !*revproxy.LogPrefix
+	if bare {
+		if !*a.quiet {
+			logOut.SetOutput(&logWriter{out: os.Stdout, bare: true})
+		}
+		logErr.SetOutput(&logWriter{out: os.Stderr, bare: true})
+	}
+
+	if revproxy.LogTarget == config.LogTargetSyslog {
+		outWriter, errWriter, err := newSyslogWriters(revproxy)
+		if err != nil {
+			logErr.Printf("Failed to connect to the syslog, logging to the standard output and error instead: %s\n",
+				err.Error())
+		} else {
+			defer outWriter.Close()
+			defer errWriter.Close()
+
+			// The syslog time-stamps the messages itself.
+			if !*a.quiet {
+				logOut.SetOutput(&logWriter{out: outWriter, bare: true})
+			}
+			logErr.SetOutput(&logWriter{out: errWriter, bare: true})
+		}
+	}
+
+	maxLogSize := int64(revproxy.LogMaxSizeMB) * 1024 * 1024
+
+	var accessLog *logfile.File
+	if revproxy.AccessLogPath != "" {
+		accessLog, err = logfile.Open(revproxy.AccessLogPath, maxLogSize)
+		if err != nil {
+			logErr.Printf("Failed to open the access log %s: %s\n", revproxy.AccessLogPath, err.Error())
+			return 1
+		}
+		defer accessLog.Close()
+
+		if !*a.quiet {
+			logOut.SetOutput(&logWriter{out: accessLog, bare: bare})
+		}
+	}
+
+	if revproxy.ErrorLogPath != "" {
+		errorLog := accessLog
+		if revproxy.ErrorLogPath != revproxy.AccessLogPath {
+			errorLog, err = logfile.Open(revproxy.ErrorLogPath, maxLogSize)
+			if err != nil {
+				logErr.Printf("Failed to open the error log %s: %s\n", revproxy.ErrorLogPath, err.Error())
+				return 1
+			}
+			defer errorLog.Close()
+		}
+
+		logErr.SetOutput(&logWriter{out: errorLog, bare: bare})
+	}
+
+	for _, warning := range config.Warnings(revproxy) {
+		logErr.Printf("Warning: %s\n", warning)
+	}
+
+	if *a.waitForUpstreams > 0 {
+		var targets []*url.URL
+		for _, route := range revproxy.Routes {
+			for _, target := range route.AllTargets() {
+				if strings.HasPrefix(target, "/") {
+					continue
+				}
+
+				parsedURL, err := url.ParseRequestURI(target)
+				if err != nil {
+					continue
+				}
+
+				targets = append(targets, parsedURL)
+			}
+		}
+
+		logOut.Printf("Waiting up to %s for %d upstream(s) to become reachable\n",
+			a.waitForUpstreams.String(), len(targets))
+
+		unreachable, err := upstream.WaitFor(targets, *a.waitForUpstreams, logOut)
+		if err != nil {
+			logErr.Printf("Failed to wait for the upstreams: %s\n", err.Error())
+			return 1
+		}
+
+		if len(unreachable) > 0 {
+			logErr.Printf("Timed out waiting for the upstreams, serving anyhow: %s\n",
+				strings.Join(unreachable, ", "))
+		}
+	}
+
+	failures := int32(0)  // atomic variable, increased on failures to start one of the servers
+	var wg sync.WaitGroup // waits for the servers and the background goroutines of the routers
+
+	var reg *metrics.Registry
+	var metricsd *http.Server
+	if revproxy.MetricsAddress != "" {
+		reg = metrics.New()
+
+		metricsRouter := http.NewServeMux()
+		metricsRouter.Handle("/metrics", reg)
+
+		metricsd = &http.Server{Addr: revproxy.MetricsAddress, Handler: metricsRouter}
+		setTimeouts(metricsd, revproxy)
+	}
+
+	router, err := newReloadableRouter(context.Background(), &wg, *a.revproxyPath, revproxy, reg, logOut, logErr)
+	if err != nil {
+		logErr.Printf("Failed to set up the router: %s\n", err.Error())
+		wg.Wait()
+		return 1
+	}
+
+	logRouteTable(revproxy, logOut)
+
+	var httpsAddr atomic.Value // holds the address string of the HTTPS server
+	httpsAddr.Store(revproxy.HttpsAddress)
+
+	httpd, httpsd, certs, err := setupServers(router, revproxy, &httpsAddr, logOut, logErr)
 	if err != nil {
 		logErr.Printf("Failed to set up the servers: %s\n", err.Error())
+		router.Stop()
+		wg.Wait()
 		return 1
 	}
 
-	failures := int32(0)  // atomic variable, increased on failures to start one of the servers
-	var wg sync.WaitGroup // synchronizes printing of Route tables
+	var httpAddr atomic.Value // holds the address string of the HTTP server
+	httpAddr.Store(revproxy.HttpAddress)
+
+	httpServer := &rebindableServer{
+		srv:           httpd,
+		name:          "HTTP",
+		addr:          &httpAddr,
+		address:       func(cfg *config.Config) string { return cfg.HttpAddress },
+		proxyProtocol: revproxy.ProxyProtocol,
+		logOut:        logOut,
+		logErr:        logErr}
+
+	router.servers = []*rebindableServer{httpServer}
+
+	var httpsServer *rebindableServer
+	if httpsd != nil {
+		httpsServer = &rebindableServer{
+			srv:           httpsd,
+			name:          "HTTPS",
+			useTLS:        true,
+			addr:          &httpsAddr,
+			address:       func(cfg *config.Config) string { return cfg.HttpsAddress },
+			proxyProtocol: revproxy.ProxyProtocol,
+			logOut:        logOut,
+			logErr:        logErr}
+
+		router.servers = append(router.servers, httpsServer)
+	}
+
+	// The sockets passed by systemd on socket activation replace binding the addresses: the first one serves
+	// HTTP and the second one HTTPS.
+	inherited, err := systemd.Listeners()
+	if err != nil {
+		logErr.Printf("Failed to take over the sockets passed by systemd: %s\n", err.Error())
+		router.Stop()
+		wg.Wait()
+		return 1
+	}
+
+	var httpLn, httpsLn net.Listener
+	for i, ln := range inherited {
+		switch {
+		case i == 0:
+			httpLn = ln
+			logOut.Printf("Serving HTTP on the socket passed by systemd: %s\n", ln.Addr().String())
+
+		case i == 1 && httpsd != nil:
+			httpsLn = ln
+			logOut.Printf("Serving HTTPS on the socket passed by systemd: %s\n", ln.Addr().String())
+
+		default:
+			logErr.Printf("Closing the unused socket passed by systemd: %s\n", ln.Addr().String())
+			ln.Close()
+		}
+	}
+
+	// bound counts the listeners of the HTTP and HTTPS servers which have been bound.
+	bound := int32(0)
+
+	listeners := int32(1)
+	if httpsd != nil {
+		listeners++
+	}
+
+	// draining is set once the servers are shutting down and finish the requests in flight.
+	draining := int32(0)
+
+	var healthd *http.Server
+	if revproxy.HealthAddress != "" {
+		// The probes fail during the drain so that the load balancers stop sending new requests.
+		live := func() bool { return atomic.LoadInt32(&draining) == 0 }
+		ready := func() bool { return live() && atomic.LoadInt32(&bound) == listeners }
+
+		healthd = &http.Server{
+			Addr:    revproxy.HealthAddress,
+			Handler: setupHealthRouter(live, ready, logOut, logErr)}
+		setTimeouts(healthd, revproxy)
+	}
+
+	sigterm.RegisterSIGHUPHandler(func() {
+		logOut.Printf("Received SIGHUP, reloading the config from %s\n", *a.revproxyPath)
+
+		err := router.Reload()
+		if err != nil {
+			logErr.Printf("Failed to reload the config, keeping the previous one: %s\n", err.Error())
+		} else {
+			logOut.Println("Reloaded the config.")
+		}
+
+		// The certificate files are taken from the initial config, so they are checked even if
+		// the new config is invalid.
+		if certs != nil {
+			certs.Reload()
+		}
+	})
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		logOut.Printf("Listening for HTTP requests on the address: %#v\n", revproxy.HttpAddress)
+		if httpLn == nil {
+			logOut.Printf("Listening for HTTP requests on the address: %#v\n", revproxy.HttpAddress)
+		}
 
-		err = httpd.ListenAndServe()
+		// Each server goroutine has its own err so that they do not race on it.
+		err := httpServer.ListenAndServe(httpLn, func() { atomic.AddInt32(&bound, 1) })
 		if err != http.ErrServerClosed {
-			logErr.Printf("Failed to listen and serve on %s: %s\n", revproxy.HttpAddress, err.Error())
+			logErr.Printf("The HTTP server failed to listen and serve on %s: %s\n",
+				httpAddr.Load().(string), err.Error())
 			atomic.AddInt32(&failures, 1)
 		}
 		logOut.Println("Goodbye from the http server.")
@@ -466,17 +3779,74 @@ func run() int {
 		go func() {
 			defer wg.Done()
 
-			logOut.Printf("Listening for HTTPS requests on the address: %#v\n", revproxy.HttpsAddress)
+			if httpsLn == nil {
+				logOut.Printf("Listening for HTTPS requests on the address: %#v\n", revproxy.HttpsAddress)
+			}
 
-			err = httpsd.ListenAndServeTLS(revproxy.SslCertPath, revproxy.SslKeyPath)
+			err := httpsServer.ListenAndServe(httpsLn, func() { atomic.AddInt32(&bound, 1) })
 			if err != http.ErrServerClosed {
-				logErr.Printf("Failed to listen and serve on %s: %s\n", revproxy.HttpsAddress, err.Error())
+				logErr.Printf("The HTTPS server failed to listen and serve on %s: %s\n",
+					httpsAddr.Load().(string), err.Error())
 				atomic.AddInt32(&failures, 1)
 			}
 			logOut.Println("Goodbye from the https server.")
 		}()
 	}
 
+	if healthd != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			logOut.Printf("Listening for health probes on the address: %#v\n", revproxy.HealthAddress)
+
+			err := healthd.ListenAndServe()
+			if err != http.ErrServerClosed {
+				logErr.Printf("The health server failed to listen and serve on %s: %s\n",
+					revproxy.HealthAddress, err.Error())
+				atomic.AddInt32(&failures, 1)
+			}
+			logOut.Println("Goodbye from the health server.")
+		}()
+	}
+
+	if metricsd != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			logOut.Printf("Listening for metrics requests on the address: %#v\n", revproxy.MetricsAddress)
+
+			err := metricsd.ListenAndServe()
+			if err != http.ErrServerClosed {
+				logErr.Printf("The metrics server failed to listen and serve on %s: %s\n",
+					revproxy.MetricsAddress, err.Error())
+				atomic.AddInt32(&failures, 1)
+			}
+			logOut.Println("Goodbye from the metrics server.")
+		}()
+	}
+
+	// watchCtx is cancelled on shutdown to stop watching the config and the certificate files.
+	watchCtx, cancelWatch := context.WithCancel(context.Background())
+	defer cancelWatch()
+
+	if *a.watch {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			router.Watch(watchCtx, 2*time.Second)
+		}()
+	}
+
+	if certs != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			certs.Watch(watchCtx, certificateWatchInterval)
+		}()
+	}
+
 	sigterm.RegisterSIGTERMHandler()
 
 	wg.Add(1)
@@ -485,19 +3855,66 @@ func run() int {
 		for !sigterm.ReceivedSIGTERM() && atomic.LoadInt32(&failures) == 0 {
 			time.Sleep(time.Second)
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+		if n := atomic.LoadInt32(&failures); n > 0 {
+			logErr.Printf("Shutting down the remaining servers since %d server(s) failed, see above\n", n)
+		}
+
+		atomic.StoreInt32(&draining, 1)
+
+		// The servers keep accepting new connections until the load balancers noticed the failing probes.
+		// There is nothing to wait for if a server already failed.
+		if delay := time.Duration(revproxy.ShutdownDelaySeconds) * time.Second; delay > 0 &&
+			atomic.LoadInt32(&failures) == 0 {
+
+			logOut.Printf("Draining, accepting new connections for another %s\n", delay.String())
+			time.Sleep(delay)
+		}
+
+		shutdownTimeout := secondsOr(revproxy.ShutdownTimeoutSeconds, 30*time.Second)
+		logOut.Printf("Shutting down, waiting up to %s for the requests in flight\n", shutdownTimeout.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 		defer cancel()
-		httpd.Shutdown(ctx)
 
-		if httpsd != nil {
-			httpsd.Shutdown(ctx)
+		// The HTTP and HTTPS servers are drained in parallel so that neither accepts new connections
+		// while the other one is draining.
+		var drain sync.WaitGroup
+		for _, srv := range []*http.Server{httpd, httpsd} {
+			if srv == nil {
+				continue
+			}
+
+			srv := srv
+			drain.Add(1)
+			go func() {
+				defer drain.Done()
+				srv.Shutdown(ctx)
+			}()
 		}
+		drain.Wait()
+
+		if metricsd != nil {
+			metricsd.Shutdown(ctx)
+		}
+
+		if healthd != nil {
+			healthd.Shutdown(ctx)
+		}
+
+		router.Stop()
+		cancelWatch()
 	}()
 
 	wg.Wait()
 
 	logOut.Println("Goodbye from revproxyry.")
 
+	// The supervisor needs to tell a failure to start one of the servers from a clean shutdown.
+	if atomic.LoadInt32(&failures) > 0 {
+		return 1
+	}
+
 	return 0
 }
 
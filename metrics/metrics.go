@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// buckets are the upper bounds of the request duration histogram in seconds.
+var buckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram represents the request durations observed for a route prefix.
+type histogram struct {
+	counts []uint64 // cumulative counts per bucket
+	sum    float64
+	count  uint64
+}
+
+// Registry collects the request metrics and exposes them in Prometheus text format.
+type Registry struct {
+	mu sync.Mutex
+
+	requestsTotal uint64
+	byStatusClass map[string]uint64
+	durations     map[string]*histogram
+}
+
+// New creates an empty registry.
+func New() *Registry {
+	return &Registry{
+		byStatusClass: make(map[string]uint64),
+		durations:     make(map[string]*histogram)}
+}
+
+// Observe records a handled request.
+func (r *Registry) Observe(prefix string, statusCode int, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requestsTotal++
+	r.byStatusClass[fmt.Sprintf("%dxx", statusCode/100)]++
+
+	h, ok := r.durations[prefix]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(buckets))}
+		r.durations[prefix] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// escape escapes the label value as required by the Prometheus text format.
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// ServeHTTP writes the metrics in Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+
+	b.WriteString("# HELP revproxyry_requests_total Total number of handled requests.\n")
+	b.WriteString("# TYPE revproxyry_requests_total counter\n")
+	fmt.Fprintf(&b, "revproxyry_requests_total %d\n", r.requestsTotal)
+
+	classes := make([]string, 0, len(r.byStatusClass))
+	for class := range r.byStatusClass {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	b.WriteString("# HELP revproxyry_requests_by_status_class_total Number of handled requests by status class.\n")
+	b.WriteString("# TYPE revproxyry_requests_by_status_class_total counter\n")
+	for _, class := range classes {
+		fmt.Fprintf(&b, "revproxyry_requests_by_status_class_total{class=\"%s\"} %d\n",
+			class, r.byStatusClass[class])
+	}
+
+	prefixes := make([]string, 0, len(r.durations))
+	for prefix := range r.durations {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	b.WriteString("# HELP revproxyry_request_duration_seconds Duration of the handled requests by route prefix.\n")
+	b.WriteString("# TYPE revproxyry_request_duration_seconds histogram\n")
+	for _, prefix := range prefixes {
+		h := r.durations[prefix]
+		label := escape(prefix)
+
+		for i, bound := range buckets {
+			fmt.Fprintf(&b, "revproxyry_request_duration_seconds_bucket{prefix=\"%s\",le=\"%s\"} %d\n",
+				label, formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(&b, "revproxyry_request_duration_seconds_bucket{prefix=\"%s\",le=\"+Inf\"} %d\n",
+			label, h.count)
+		fmt.Fprintf(&b, "revproxyry_request_duration_seconds_sum{prefix=\"%s\"} %s\n", label, formatFloat(h.sum))
+		fmt.Fprintf(&b, "revproxyry_request_duration_seconds_count{prefix=\"%s\"} %d\n", label, h.count)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
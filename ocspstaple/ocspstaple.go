@@ -0,0 +1,287 @@
+package ocspstaple
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/Parquery/revproxyry/tlscert"
+)
+
+// Intervals of refreshing the OCSP response
+const (
+	// retryInterval is the wait after a failed fetch.
+	retryInterval = 10 * time.Minute
+
+	// minRefreshInterval bounds the refresh if the responder indicates an imminent next update.
+	minRefreshInterval = time.Minute
+)
+
+// errUpdated indicates that the certificate was updated while its response was being fetched.
+var errUpdated = errors.New("the certificate was updated during the fetch")
+
+// Stapler staples the OCSP response of the issuer's responder to a certificate.
+type Stapler struct {
+	cert atomic.Value // *tls.Certificate with the current staple, if any
+
+	mu     sync.Mutex // guards leaf, issuer, nextUpdate and the stores of cert
+	leaf   *x509.Certificate
+	issuer *x509.Certificate // nil if the chain does not contain the issuer
+
+	// nextUpdate is the time after which the current staple expires; zero if there is no staple or it never expires.
+	nextUpdate time.Time
+
+	// updated signals that the certificate has been updated and its response needs to be fetched.
+	updated chan struct{}
+
+	client *http.Client
+	logOut *log.Logger
+	logErr *log.Logger
+}
+
+// New loads the certificate/key pair whose OCSP responses are to be stapled. An encrypted key is decrypted with
+// the passphrase.
+//
+// The certificate file needs to contain the issuer after the leaf so that the OCSP request can be created.
+func New(certFile string, keyFile string, passphrase string, logOut *log.Logger, logErr *log.Logger) (*Stapler, error) {
+	cert, err := tlscert.Load(certFile, keyFile, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the certificate %s with the key %s: %s",
+			certFile, keyFile, err.Error())
+	}
+
+	s := &Stapler{
+		updated: make(chan struct{}, 1),
+		client:  &http.Client{Timeout: 30 * time.Second},
+		logOut:  logOut,
+		logErr:  logErr}
+
+	s.leaf, s.issuer, err = parseChain(&cert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the certificate %s: %s", certFile, err.Error())
+	}
+
+	s.cert.Store(&cert)
+
+	return s, nil
+}
+
+// parseChain parses the leaf and, if present, the issuer which follows it in the chain.
+func parseChain(cert *tls.Certificate) (leaf *x509.Certificate, issuer *x509.Certificate, err error) {
+	leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(cert.Certificate) > 1 {
+		issuer, err = x509.ParseCertificate(cert.Certificate[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse the issuer: %s", err.Error())
+		}
+	}
+
+	return leaf, issuer, nil
+}
+
+// Update replaces the certificate, e.g., after it has been renewed. The new certificate is served right away
+// without a staple until the response for it has been fetched.
+func (s *Stapler) Update(cert *tls.Certificate) error {
+	leaf, issuer, err := parseChain(cert)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.leaf = leaf
+	s.issuer = issuer
+	s.nextUpdate = time.Time{}
+	s.cert.Store(cert)
+	s.mu.Unlock()
+
+	select {
+	case s.updated <- struct{}{}:
+	default:
+		// A fetch is already pending.
+	}
+
+	return nil
+}
+
+// GetCertificate returns the certificate with the latest stapled OCSP response.
+func (s *Stapler) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.cert.Load().(*tls.Certificate), nil
+}
+
+// fetch requests the OCSP response from the responder and staples it.
+//
+// It returns the time when the response should be refreshed.
+func (s *Stapler) fetch() (time.Time, error) {
+	s.mu.Lock()
+	current := s.cert.Load().(*tls.Certificate)
+	leaf := s.leaf
+	issuer := s.issuer
+	s.mu.Unlock()
+
+	if len(leaf.OCSPServer) == 0 {
+		return time.Time{}, errors.New("the certificate does not specify an OCSP responder")
+	}
+
+	if issuer == nil {
+		return time.Time{}, errors.New("the certificate file does not contain the issuer after the leaf")
+	}
+
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to create the OCSP request: %s", err.Error())
+	}
+
+	resp, err := s.client.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("unexpected status code from the OCSP responder: %d", resp.StatusCode)
+	}
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read the OCSP response: %s", err.Error())
+	}
+
+	parsed, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse the OCSP response: %s", err.Error())
+	}
+
+	if parsed.Status != ocsp.Good {
+		return time.Time{}, fmt.Errorf("the OCSP responder reports the certificate as not good: %d", parsed.Status)
+	}
+
+	if !parsed.NextUpdate.IsZero() && !time.Now().Before(parsed.NextUpdate) {
+		return time.Time{}, fmt.Errorf("the OCSP response expired on %s",
+			parsed.NextUpdate.UTC().Format(time.RFC3339))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cert.Load().(*tls.Certificate) != current {
+		// The response for the updated certificate is fetched on the update signal.
+		return time.Time{}, errUpdated
+	}
+
+	// The certificate is copied so that the handshakes in progress are not affected.
+	cert := *current
+	cert.OCSPStaple = raw
+	s.cert.Store(&cert)
+	s.nextUpdate = parsed.NextUpdate
+
+	if parsed.NextUpdate.IsZero() {
+		return time.Now().Add(time.Hour), nil
+	}
+
+	// Refresh halfway to the next update so that the staple never expires.
+	return parsed.ThisUpdate.Add(parsed.NextUpdate.Sub(parsed.ThisUpdate) / 2), nil
+}
+
+// dropExpired removes the staple from the certificate once its next update passed since the clients reject
+// an expired staple, while they still accept a certificate without one.
+//
+// It returns the time when the current staple expires, or zero if there is no staple to expire.
+func (s *Stapler) dropExpired(now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nextUpdate.IsZero() {
+		return time.Time{}
+	}
+
+	if now.Before(s.nextUpdate) {
+		return s.nextUpdate
+	}
+
+	cert := *s.cert.Load().(*tls.Certificate)
+	cert.OCSPStaple = nil
+	s.cert.Store(&cert)
+	s.nextUpdate = time.Time{}
+
+	s.logErr.Printf("The stapled OCSP response for %s expired, serving the certificate without it\n",
+		s.leaf.Subject.String())
+
+	return time.Time{}
+}
+
+// subject returns the subject of the current certificate for the logs.
+func (s *Stapler) subject() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.leaf.Subject.String()
+}
+
+// Start spawns a goroutine which fetches the OCSP response immediately and refreshes it until the context is done.
+// If the refreshes keep failing, the previous staple is served until its next update and dropped afterwards.
+//
+// An error is returned if the certificate does not specify an OCSP responder or the issuer is missing since
+// there would be nothing to staple.
+func (s *Stapler) Start(ctx context.Context) error {
+	if len(s.leaf.OCSPServer) == 0 {
+		return errors.New("the certificate does not specify an OCSP responder")
+	}
+
+	if s.issuer == nil {
+		return errors.New("the certificate file does not contain the issuer after the leaf")
+	}
+
+	go func() {
+		for {
+			wait := retryInterval
+
+			next, err := s.fetch()
+			switch {
+			case err == errUpdated:
+				wait = 0
+
+			case err != nil:
+				s.logErr.Printf("Failed to staple the OCSP response for %s, retrying in %s: %s\n",
+					s.subject(), retryInterval, err.Error())
+
+				// The previous staple is served until it expires, so the loop also wakes up to drop it.
+				if expires := s.dropExpired(time.Now()); !expires.IsZero() && time.Until(expires) < wait {
+					wait = time.Until(expires)
+				}
+
+			default:
+				wait = time.Until(next)
+				if wait < minRefreshInterval {
+					wait = minRefreshInterval
+				}
+
+				s.logOut.Printf("Stapled the OCSP response for %s, refreshing in %s\n",
+					s.subject(), wait.Round(time.Second))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.updated:
+			case <-time.After(wait):
+			}
+		}
+	}()
+
+	return nil
+}
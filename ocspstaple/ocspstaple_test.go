@@ -0,0 +1,36 @@
+package ocspstaple
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestDropExpired(t *testing.T) {
+	nextUpdate := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	s := &Stapler{
+		leaf:       &x509.Certificate{},
+		nextUpdate: nextUpdate,
+		logErr:     log.New(ioutil.Discard, "", 0)}
+	s.cert.Store(&tls.Certificate{OCSPStaple: []byte("some-staple")})
+
+	if expires := s.dropExpired(nextUpdate.Add(-time.Second)); !expires.Equal(nextUpdate) {
+		t.Errorf("expected the staple to expire on %s, got %s", nextUpdate, expires)
+	}
+
+	if staple := s.cert.Load().(*tls.Certificate).OCSPStaple; string(staple) != "some-staple" {
+		t.Errorf("expected the staple to be kept before its next update, got %#v", string(staple))
+	}
+
+	if expires := s.dropExpired(nextUpdate); !expires.IsZero() {
+		t.Errorf("expected no staple to expire after dropping it, got %s", expires)
+	}
+
+	if staple := s.cert.Load().(*tls.Certificate).OCSPStaple; staple != nil {
+		t.Errorf("expected the staple to be dropped on its next update, got %#v", string(staple))
+	}
+}
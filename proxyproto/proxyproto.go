@@ -0,0 +1,209 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// v2Signature starts the header of the version 2 of the PROXY protocol.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1MaxLength is the maximum length of the header of the version 1 including the CRLF.
+const v1MaxLength = 107
+
+// Listener accepts the connections which start with the header of the PROXY protocol (version 1 or 2).
+//
+// The header is parsed lazily on the first read or on the first call to RemoteAddr so that a slow client does not
+// block the accepting loop. The connections without a valid header are closed.
+type Listener struct {
+	net.Listener
+
+	// timeout limits the time to read the header. If 0, unlimited.
+	timeout time.Duration
+}
+
+// NewListener wraps the listener so that the client addresses are taken from the PROXY protocol headers.
+func NewListener(ln net.Listener, timeout time.Duration) *Listener {
+	return &Listener{Listener: ln, timeout: timeout}
+}
+
+// Accept implements net.Listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// Conn is a connection whose remote address is given by the PROXY protocol header.
+type Conn struct {
+	net.Conn
+
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+
+		c.remoteAddr, c.err = parseHeader(c.reader)
+		if c.err != nil {
+			c.err = fmt.Errorf("invalid PROXY protocol header from %s: %s", c.Conn.RemoteAddr(), c.err.Error())
+			c.Conn.Close()
+		}
+	})
+}
+
+// Read reads from the connection after the header.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the address of the client given in the header. If the header conveys no address
+// (e.g., a health check of the proxy), the address of the peer is returned.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remoteAddr == nil {
+		return c.Conn.RemoteAddr()
+	}
+
+	return c.remoteAddr
+}
+
+// parseHeader parses the header of either version. A nil address is returned if the header conveys none.
+func parseHeader(r *bufio.Reader) (net.Addr, error) {
+	prefix, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(prefix, v2Signature) {
+		return parseV2(r)
+	}
+
+	if bytes.HasPrefix(prefix, []byte("PROXY ")) {
+		return parseV1(r)
+	}
+
+	return nil, fmt.Errorf("missing header")
+}
+
+// parseV1 parses the human-readable header, e.g., "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n".
+func parseV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= v1MaxLength {
+			return nil, fmt.Errorf("header of version 1 longer than %d bytes", v1MaxLength)
+		}
+
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("unexpected header of version 1: %#v", string(line))
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid source address in the header of version 1: %#v", fields[2])
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source port in the header of version 1: %#v", fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// parseV2 parses the binary header.
+func parseV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	verCmd, family := header[12], header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unexpected version in the header of version 2: %d", verCmd>>4)
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch verCmd & 0xF {
+	case 0x0:
+		// LOCAL: the connection was established by the proxy itself, e.g., for a health check.
+		return nil, nil
+
+	case 0x1:
+		// PROXY
+
+	default:
+		return nil, fmt.Errorf("unexpected command in the header of version 2: %d", verCmd&0xF)
+	}
+
+	switch family {
+	case 0x11, 0x12:
+		// TCP or UDP over IPv4: source address, destination address, source port, destination port.
+		if length < 12 {
+			return nil, fmt.Errorf("too short IPv4 addresses in the header of version 2: %d", length)
+		}
+
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+
+	case 0x21, 0x22:
+		// TCP or UDP over IPv6
+		if length < 36 {
+			return nil, fmt.Errorf("too short IPv6 addresses in the header of version 2: %d", length)
+		}
+
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+
+	default:
+		// Unix sockets and unspecified families convey no usable client address.
+		return nil, nil
+	}
+}
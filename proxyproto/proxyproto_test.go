@@ -0,0 +1,223 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// v2Header builds a header of the version 2 with the given version and command, address family and payload.
+func v2Header(verCmd byte, family byte, payload []byte) []byte {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, verCmd, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(payload)))
+	return append(header, payload...)
+}
+
+// v4Payload encodes the IPv4 addresses and ports of the version 2.
+func v4Payload(src string, dst string, srcPort uint16, dstPort uint16) []byte {
+	payload := append([]byte{}, net.ParseIP(src).To4()...)
+	payload = append(payload, net.ParseIP(dst).To4()...)
+	payload = binary.BigEndian.AppendUint16(payload, srcPort)
+	return binary.BigEndian.AppendUint16(payload, dstPort)
+}
+
+// v6Payload encodes the IPv6 addresses and ports of the version 2.
+func v6Payload(src string, dst string, srcPort uint16, dstPort uint16) []byte {
+	payload := append([]byte{}, net.ParseIP(src).To16()...)
+	payload = append(payload, net.ParseIP(dst).To16()...)
+	payload = binary.BigEndian.AppendUint16(payload, srcPort)
+	return binary.BigEndian.AppendUint16(payload, dstPort)
+}
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      []byte
+		expected    string // empty if no address is conveyed
+		expectedErr string
+	}{
+		{name: "v1 TCP4",
+			header:   []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"),
+			expected: "192.168.0.1:56324"},
+		{name: "v1 TCP6",
+			header:   []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"),
+			expected: "[2001:db8::1]:56324"},
+		{name: "v1 UNKNOWN",
+			header: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v1 UNKNOWN with addresses",
+			header: []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n")},
+		{name: "v1 TCP4 with an IPv6 address",
+			header:      []byte("PROXY TCP4 2001:db8::1 192.168.0.11 56324 443\r\n"),
+			expectedErr: `invalid source address in the header of version 1: "2001:db8::1"`},
+		{name: "v1 invalid port",
+			header:      []byte("PROXY TCP4 192.168.0.1 192.168.0.11 65536 443\r\n"),
+			expectedErr: `invalid source port in the header of version 1: "65536"`},
+		{name: "v1 missing fields",
+			header:      []byte("PROXY TCP4 192.168.0.1\r\n"),
+			expectedErr: `unexpected header of version 1: "PROXY TCP4 192.168.0.1\r\n"`},
+		{name: "v1 truncated",
+			header:      []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324"),
+			expectedErr: "EOF"},
+		{name: "v1 oversized",
+			header:      []byte("PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n"),
+			expectedErr: "header of version 1 longer than 107 bytes"},
+		{name: "v2 PROXY over TCP4",
+			header:   v2Header(0x21, 0x11, v4Payload("192.168.0.1", "192.168.0.11", 56324, 443)),
+			expected: "192.168.0.1:56324"},
+		{name: "v2 PROXY over TCP6",
+			header:   v2Header(0x21, 0x21, v6Payload("2001:db8::1", "2001:db8::2", 56324, 443)),
+			expected: "[2001:db8::1]:56324"},
+		{name: "v2 PROXY with TLVs",
+			header: v2Header(0x21, 0x11,
+				append(v4Payload("192.168.0.1", "192.168.0.11", 56324, 443), 0x04, 0x00, 0x01, 0x00)),
+			expected: "192.168.0.1:56324"},
+		{name: "v2 PROXY over a Unix socket",
+			header: v2Header(0x21, 0x31, make([]byte, 216))},
+		{name: "v2 LOCAL",
+			header: v2Header(0x20, 0x00, nil)},
+		{name: "v2 LOCAL with addresses",
+			header: v2Header(0x20, 0x11, v4Payload("192.168.0.1", "192.168.0.11", 56324, 443))},
+		{name: "v2 unexpected version",
+			header:      v2Header(0x11, 0x11, v4Payload("192.168.0.1", "192.168.0.11", 56324, 443)),
+			expectedErr: "unexpected version in the header of version 2: 1"},
+		{name: "v2 unexpected command",
+			header:      v2Header(0x22, 0x11, v4Payload("192.168.0.1", "192.168.0.11", 56324, 443)),
+			expectedErr: "unexpected command in the header of version 2: 2"},
+		{name: "v2 too short IPv4 addresses",
+			header:      v2Header(0x21, 0x11, make([]byte, 8)),
+			expectedErr: "too short IPv4 addresses in the header of version 2: 8"},
+		{name: "v2 too short IPv6 addresses",
+			header:      v2Header(0x21, 0x21, make([]byte, 12)),
+			expectedErr: "too short IPv6 addresses in the header of version 2: 12"},
+		{name: "v2 truncated payload",
+			header:      v2Header(0x21, 0x11, v4Payload("192.168.0.1", "192.168.0.11", 56324, 443))[:20],
+			expectedErr: "unexpected EOF"},
+		{name: "v2 truncated header",
+			header:      v2Header(0x21, 0x11, nil)[:14],
+			expectedErr: "unexpected EOF"},
+		{name: "missing header",
+			header:      []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"),
+			expectedErr: "missing header"},
+		{name: "shorter than the signature",
+			header:      []byte("PROXY"),
+			expectedErr: "EOF"},
+	}
+
+	for _, test := range tests {
+		// The data following the header must be left for the reader of the connection.
+		r := bufio.NewReader(bytes.NewReader(append(append([]byte{}, test.header...), "rest"...)))
+		if test.expectedErr != "" {
+			r = bufio.NewReader(bytes.NewReader(test.header))
+		}
+
+		addr, err := parseHeader(r)
+
+		switch {
+		case test.expectedErr != "" && err == nil:
+			t.Errorf("%s: expected the error %#v, got none", test.name, test.expectedErr)
+
+		case test.expectedErr != "" && err.Error() != test.expectedErr:
+			t.Errorf("%s: expected the error %#v, got %#v", test.name, test.expectedErr, err.Error())
+
+		case test.expectedErr != "":
+			// expected error
+
+		case err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+
+		case test.expected == "" && addr != nil:
+			t.Errorf("%s: expected no address, got %s", test.name, addr.String())
+
+		case test.expected != "" && (addr == nil || addr.String() != test.expected):
+			t.Errorf("%s: expected the address %s, got %v", test.name, test.expected, addr)
+
+		default:
+			rest, _ := ioutil.ReadAll(r)
+			if string(rest) != "rest" {
+				t.Errorf("%s: expected the data after the header to be %#v, got %#v", test.name, "rest", string(rest))
+			}
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	pl := NewListener(ln, time.Second)
+
+	send := func(data string) net.Conn {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = client.Write([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return client
+	}
+
+	// The client address is taken from the header and the data follows.
+	client := send("PROXY TCP4 198.51.100.1 192.0.2.1 4321 443\r\nhello")
+	defer client.Close()
+
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := conn.RemoteAddr().String(); got != "198.51.100.1:4321" {
+		t.Errorf("expected the remote address from the header, got %s", got)
+	}
+
+	data := make([]byte, 5)
+	_, err = conn.Read(data)
+	if err != nil || string(data) != "hello" {
+		t.Errorf("expected the data after the header, got %#v and the error %v", string(data), err)
+	}
+	conn.Close()
+
+	// The connection without a header is closed.
+	other := send("hello")
+	defer other.Close()
+
+	conn, err = pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = conn.Read(data)
+	if err == nil || !strings.Contains(err.Error(), "invalid PROXY protocol header") {
+		t.Errorf("expected an error about the missing header, got: %v", err)
+	}
+
+	// The header needs to arrive within the timeout.
+	silent, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	conn, err = pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = conn.Read(data)
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("expected the read of the header to time out, got: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// failureRecord holds the recent failures of a single client.
+type failureRecord struct {
+	count        int
+	windowStart  time.Time
+	blockedUntil time.Time
+}
+
+// Failures blocks the clients which failed too often within a window (e.g., failed authentications).
+type Failures struct {
+	maxFailures int
+	window      time.Duration
+	cooldown    time.Duration
+
+	mu      sync.Mutex
+	records map[string]*failureRecord
+}
+
+// NewFailures creates a tracker blocking a client for the cooldown after maxFailures failures within the window.
+func NewFailures(maxFailures int, window time.Duration, cooldown time.Duration) *Failures {
+	return &Failures{
+		maxFailures: maxFailures,
+		window:      window,
+		cooldown:    cooldown,
+		records:     make(map[string]*failureRecord)}
+}
+
+// Blocked checks whether the client is blocked at the given time.
+func (f *Failures) Blocked(client string, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r, ok := f.records[client]
+	return ok && now.Before(r.blockedUntil)
+}
+
+// Fail records a failure of the client at the given time and blocks the client if it failed too often.
+func (f *Failures) Fail(client string, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r, ok := f.records[client]
+	if !ok || now.Sub(r.windowStart) > f.window {
+		r = &failureRecord{windowStart: now}
+		f.records[client] = r
+	}
+
+	r.count++
+	if r.count >= f.maxFailures {
+		r.blockedUntil = now.Add(f.cooldown)
+		r.count = 0
+		r.windowStart = now
+	}
+}
+
+// Reset forgets the failures of the client, e.g., after a success.
+func (f *Failures) Reset(client string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.records, client)
+}
+
+// Evict removes the records of the clients which are neither blocked nor failed within the window.
+func (f *Failures) Evict(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for client, r := range f.records {
+		if !now.Before(r.blockedUntil) && now.Sub(r.windowStart) > f.window {
+			delete(f.records, client)
+		}
+	}
+}
+
+// StartEvicting spawns a goroutine which periodically evicts the idle clients until the context is done.
+func (f *Failures) StartEvicting(ctx context.Context, wg *sync.WaitGroup, interval time.Duration) {
+	startEvicting(ctx, wg, interval, f.Evict)
+}
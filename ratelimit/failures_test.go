@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailures(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type step struct {
+		fail    bool          // records a failure of the client
+		reset   bool          // resets the client
+		at      time.Duration // time of the step since the start
+		blocked bool          // expected state of the client right after the step
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{name: "blocked after the maximum failures",
+			steps: []step{
+				{fail: true, at: 0},
+				{fail: true, at: time.Second},
+				{fail: true, at: 2 * time.Second, blocked: true}}},
+		{name: "unblocked after the cooldown",
+			steps: []step{
+				{fail: true, at: 0},
+				{fail: true, at: 0},
+				{fail: true, at: 0, blocked: true},
+				{at: 5*time.Minute - time.Second, blocked: true},
+				{at: 5 * time.Minute}}},
+		{name: "failures expire with the window",
+			steps: []step{
+				{fail: true, at: 0},
+				{fail: true, at: time.Second},
+				{fail: true, at: time.Minute + 2*time.Second},
+				{fail: true, at: time.Minute + 3*time.Second}}},
+		{name: "failures forgotten on reset",
+			steps: []step{
+				{fail: true, at: 0},
+				{fail: true, at: time.Second},
+				{reset: true, at: 2 * time.Second},
+				{fail: true, at: 3 * time.Second},
+				{fail: true, at: 4 * time.Second}}},
+		{name: "block lifted on reset",
+			steps: []step{
+				{fail: true, at: 0},
+				{fail: true, at: 0},
+				{fail: true, at: 0, blocked: true},
+				{reset: true, at: time.Second}}},
+	}
+
+	for _, test := range tests {
+		f := NewFailures(3, time.Minute, 5*time.Minute)
+
+		for i, s := range test.steps {
+			now := start.Add(s.at)
+
+			switch {
+			case s.fail:
+				f.Fail("some-client", now)
+			case s.reset:
+				f.Reset("some-client")
+			}
+
+			if blocked := f.Blocked("some-client", now); blocked != s.blocked {
+				t.Errorf("%s: expected blocked to be %v after the step %d, got %v", test.name, s.blocked, i, blocked)
+			}
+
+			if f.Blocked("other-client", now) {
+				t.Errorf("%s: expected the other client not to be blocked after the step %d", test.name, i)
+			}
+		}
+	}
+}
+
+func TestFailuresEvict(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	f := NewFailures(2, time.Minute, 5*time.Minute)
+
+	f.Fail("blocked-client", start)
+	f.Fail("blocked-client", start)
+	f.Fail("failed-client", start)
+
+	// The blocked client is kept even after the window until its cooldown passes.
+	f.Evict(start.Add(2 * time.Minute))
+
+	if _, ok := f.records["blocked-client"]; !ok {
+		t.Errorf("expected the blocked client to be kept before the end of the cooldown")
+	}
+
+	if _, ok := f.records["failed-client"]; ok {
+		t.Errorf("expected the failed client to be evicted after the window")
+	}
+
+	f.Evict(start.Add(6 * time.Minute))
+
+	if len(f.records) != 0 {
+		t.Errorf("expected all the clients to be evicted after the cooldown, got %d record(s)", len(f.records))
+	}
+}
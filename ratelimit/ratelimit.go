@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// bucket holds the tokens of a single client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter limits the rate of requests per client with a token bucket.
+//
+// Each client starts with a full bucket of burst tokens which refills at the given rate per second.
+// A request consumes a token and is rejected if the bucket is empty.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// New creates a limiter refilling at requestsPerSecond with the given burst.
+func New(requestsPerSecond float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    requestsPerSecond,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket)}
+}
+
+// Allow consumes a token of the client at the given time and reports whether the request is allowed.
+func (l *Limiter) Allow(client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// Evict removes the buckets of the clients which have been idle long enough for their buckets to be full.
+func (l *Limiter) Evict(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// StartEvicting spawns a goroutine which periodically evicts the idle clients until the context is done.
+func (l *Limiter) StartEvicting(ctx context.Context, wg *sync.WaitGroup, interval time.Duration) {
+	startEvicting(ctx, wg, interval, l.Evict)
+}
+
+// startEvicting spawns a goroutine which periodically calls evict until the context is done.
+func startEvicting(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, evict func(now time.Time)) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				evict(now)
+			}
+		}
+	}()
+}
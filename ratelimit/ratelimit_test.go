@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type step struct {
+		at      time.Duration // time of the request since the start
+		allowed bool          // expected outcome of the request
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{name: "burst allowed at once",
+			steps: []step{
+				{at: 0, allowed: true},
+				{at: 0, allowed: true},
+				{at: 0, allowed: true},
+				{at: 0}}},
+		{name: "refilled at the rate",
+			steps: []step{
+				{at: 0, allowed: true},
+				{at: 0, allowed: true},
+				{at: 0, allowed: true},
+				{at: 400 * time.Millisecond},
+				{at: 500 * time.Millisecond, allowed: true},
+				{at: 500 * time.Millisecond},
+				{at: time.Second, allowed: true}}},
+		{name: "refill capped at the burst",
+			steps: []step{
+				{at: 0, allowed: true},
+				{at: 0, allowed: true},
+				{at: 0, allowed: true},
+				{at: time.Hour, allowed: true},
+				{at: time.Hour, allowed: true},
+				{at: time.Hour, allowed: true},
+				{at: time.Hour}}},
+		{name: "rejected requests consume no tokens",
+			steps: []step{
+				{at: 0, allowed: true},
+				{at: 0, allowed: true},
+				{at: 0, allowed: true},
+				{at: 0},
+				{at: 0},
+				{at: 500 * time.Millisecond, allowed: true}}},
+	}
+
+	for _, test := range tests {
+		l := New(2, 3)
+
+		for i, s := range test.steps {
+			now := start.Add(s.at)
+
+			if allowed := l.Allow("some-client", now); allowed != s.allowed {
+				t.Errorf("%s: expected allowed to be %v at the step %d, got %v", test.name, s.allowed, i, allowed)
+			}
+		}
+
+		if !l.Allow("other-client", start) {
+			t.Errorf("%s: expected the other client to be allowed", test.name)
+		}
+	}
+}
+
+func TestLimiterEvict(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := New(1, 2)
+
+	l.Allow("drained-client", start)
+	l.Allow("drained-client", start)
+	l.Allow("idle-client", start.Add(-time.Minute))
+
+	// The bucket of the drained client is not full yet, while the one of the idle client refilled long ago.
+	l.Evict(start.Add(time.Second))
+
+	if _, ok := l.buckets["drained-client"]; !ok {
+		t.Errorf("expected the drained client to be kept before its bucket is full")
+	}
+
+	if _, ok := l.buckets["idle-client"]; ok {
+		t.Errorf("expected the idle client with the full bucket to be evicted")
+	}
+
+	l.Evict(start.Add(2 * time.Second))
+
+	if len(l.buckets) != 0 {
+		t.Errorf("expected all the clients to be evicted once their buckets are full, got %d bucket(s)",
+			len(l.buckets))
+	}
+}
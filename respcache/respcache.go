@@ -0,0 +1,536 @@
+package respcache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the limits
+const (
+	DefaultMaxEntries    = 1000
+	DefaultMaxEntryBytes = 1 << 20
+)
+
+// Header reports whether a response was served from the cache ("HIT"), by the upstream ("MISS") or from the cache
+// after it expired since the upstream failed ("STALE").
+const Header = "X-Cache"
+
+// staleWarning is the Warning header of the stale responses.
+const staleWarning = `110 - "Response is Stale"`
+
+// errorStatus lists the status codes of the upstream failures on which a stale response is served instead.
+var errorStatus = map[int]bool{
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// cacheableStatus lists the status codes of the responses which are stored.
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// entry is a cached response.
+type entry struct {
+	key string
+
+	status int
+	header http.Header
+	body   []byte
+
+	// vary maps the canonical names of the request headers listed in the Vary header to their values.
+	vary map[string]string
+
+	stored  time.Time
+	age     time.Duration // age reported by the upstream when stored
+	expires time.Time
+
+	// mustRevalidate is set if the upstream forbids serving the response once it expired.
+	mustRevalidate bool
+}
+
+// Transport caches the responses of the underlying transport to the GET requests in memory.
+//
+// Only the responses which the upstream explicitly declares fresh by Cache-Control (s-maxage or max-age) or Expires
+// are stored. The responses marked no-store, no-cache or private, the ones setting cookies and the ones varying
+// on all the headers are never stored.
+//
+// If staleIfError is positive, the expired responses are kept for that long and served with a Warning if the
+// upstream fails (i.e., the transport returns an error such as a timeout or the upstream responds with 500, 502, 503
+// or 504), unless the upstream marked them must-revalidate.
+type Transport struct {
+	transport http.RoundTripper
+
+	maxEntries    int
+	maxEntryBytes int64
+	staleIfError  time.Duration
+
+	// allowAuthorization permits storing the responses to the requests with the Authorization header.
+	allowAuthorization bool
+
+	// now returns the current time; replaced in the tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // values are *entry
+	lru     *list.List               // most recently used first
+}
+
+// New creates a caching transport around the given one. If not positive, the limits default to
+// DefaultMaxEntries and DefaultMaxEntryBytes, respectively.
+func New(transport http.RoundTripper, maxEntries int, maxEntryBytes int64, allowAuthorization bool,
+	staleIfError time.Duration) *Transport {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+
+	if maxEntryBytes <= 0 {
+		maxEntryBytes = DefaultMaxEntryBytes
+	}
+
+	return &Transport{
+		transport:          transport,
+		maxEntries:         maxEntries,
+		maxEntryBytes:      maxEntryBytes,
+		staleIfError:       staleIfError,
+		allowAuthorization: allowAuthorization,
+		now:                time.Now,
+		entries:            make(map[string]*list.Element),
+		lru:                list.New()}
+}
+
+// clientURLKey is the context key of the host and the URL requested by the client.
+type clientURLKey struct{}
+
+// Handler records the host and the URL requested by the client in the context of the request.
+//
+// The cache is keyed on them rather than on the URL rewritten by the proxy so that the backends of a balanced route
+// share the entries.
+type Handler struct {
+	Handler http.Handler
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	clientURL := req.Host + " " + req.URL.RequestURI()
+	h.Handler.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), clientURLKey{}, clientURL)))
+}
+
+// key identifies the requested resource by the host and the URL requested by the client, if recorded by Handler,
+// and by the URL of the upstream otherwise.
+func key(req *http.Request) string {
+	if clientURL, ok := req.Context().Value(clientURLKey{}).(string); ok {
+		return clientURL
+	}
+
+	return req.Host + " " + req.URL.String()
+}
+
+// directives parses the Cache-Control headers into a map of the lower-case directives to their values.
+func directives(header http.Header) map[string]string {
+	result := make(map[string]string)
+	for _, line := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+
+			name, value := part, ""
+			if i := strings.Index(part, "="); i >= 0 {
+				name, value = part[:i], strings.Trim(strings.TrimSpace(part[i+1:]), `"`)
+			}
+
+			result[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+	}
+
+	return result
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return t.transport.RoundTrip(req)
+	}
+
+	reqDirectives := directives(req.Header)
+	_, noStore := reqDirectives["no-store"]
+	_, noCache := reqDirectives["no-cache"]
+
+	// Pragma: no-cache of HTTP/1.0 is only considered without Cache-Control.
+	if len(req.Header.Values("Cache-Control")) == 0 && strings.EqualFold(req.Header.Get("Pragma"), "no-cache") {
+		noCache = true
+	}
+
+	// The client can limit the age of the cached response with max-age.
+	maxAge := time.Duration(-1)
+	if value, ok := reqDirectives["max-age"]; ok {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			seconds = 0
+		}
+		maxAge = time.Duration(seconds) * time.Second
+	}
+
+	k := key(req)
+
+	// The client can demand a response from the upstream with no-cache.
+	if !noStore && !noCache {
+		if resp := t.lookup(k, req, maxAge); resp != nil {
+			return resp, nil
+		}
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+
+	// The stale response is only served to the clients which accept the cached responses at all.
+	if (err != nil || errorStatus[resp.StatusCode]) && t.staleIfError > 0 && !noStore && !noCache && maxAge < 0 {
+		if stale := t.lookupStale(k, req); stale != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			return stale, nil
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Header.Set(Header, "MISS")
+
+	if noStore || (req.Header.Get("Authorization") != "" && !t.allowAuthorization) {
+		return resp, nil
+	}
+
+	e := t.newEntry(k, req, resp)
+	if e == nil {
+		return resp, nil
+	}
+
+	// The body is recorded while it is streamed to the client and the entry is stored once it was read completely.
+	resp.Body = &recorder{
+		body:  resp.Body,
+		limit: t.maxEntryBytes,
+		done: func(body []byte) {
+			e.body = body
+			t.store(e)
+		}}
+
+	return resp, nil
+}
+
+// lookup returns the fresh cached response to the request, if any. If maxAge is not negative, the older responses
+// are not returned.
+func (t *Transport) lookup(k string, req *http.Request, maxAge time.Duration) *http.Response {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	el, ok := t.entries[k]
+	if !ok {
+		return nil
+	}
+
+	e := el.Value.(*entry)
+
+	now := t.now()
+	if !now.Before(e.expires) {
+		// The expired entry is kept as long as it can still be served if the upstream fails.
+		if e.mustRevalidate || !now.Before(e.expires.Add(t.staleIfError)) {
+			t.lru.Remove(el)
+			delete(t.entries, k)
+		}
+		return nil
+	}
+
+	if !e.matches(req) {
+		return nil
+	}
+
+	age := e.age + now.Sub(e.stored)
+	if maxAge >= 0 && age > maxAge {
+		return nil
+	}
+
+	t.lru.MoveToFront(el)
+
+	return e.response(req, "HIT", age)
+}
+
+// lookupStale returns the cached response to the request which expired less than staleIfError ago, if any.
+func (t *Transport) lookupStale(k string, req *http.Request) *http.Response {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	el, ok := t.entries[k]
+	if !ok {
+		return nil
+	}
+
+	e := el.Value.(*entry)
+
+	now := t.now()
+	if e.mustRevalidate || !now.Before(e.expires.Add(t.staleIfError)) || !e.matches(req) {
+		return nil
+	}
+
+	// The entry might have been stored by a concurrent request in the meantime and still be fresh.
+	if now.Before(e.expires) {
+		return e.response(req, "HIT", e.age+now.Sub(e.stored))
+	}
+
+	resp := e.response(req, "STALE", e.age+now.Sub(e.stored))
+	resp.Header.Add("Warning", staleWarning)
+
+	return resp
+}
+
+// matches checks whether the request has the same values of the headers which the response varies on.
+func (e *entry) matches(req *http.Request) bool {
+	for name, value := range e.vary {
+		if strings.Join(req.Header.Values(name), ", ") != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// response creates the response to the request from the entry with the given X-Cache and age.
+func (e *entry) response(req *http.Request, cacheStatus string, age time.Duration) *http.Response {
+	header := e.header.Clone()
+	header.Set(Header, cacheStatus)
+
+	header.Set("Age", strconv.Itoa(int(age.Seconds())))
+
+	// The max-age of the upstream already accounts for the Age it sent, so only the time in the cache is
+	// subtracted from it.
+	subtractAge(header, int((age - e.age).Seconds()))
+
+	return &http.Response{
+		Status:        strconv.Itoa(e.status) + " " + http.StatusText(e.status),
+		StatusCode:    e.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req}
+}
+
+// subtractAge subtracts the seconds from the max-age and s-maxage of the Cache-Control headers so that the served
+// response is not considered fresh for longer than the cached one. The other directives are kept as-is.
+func subtractAge(header http.Header, seconds int) {
+	lines := header.Values("Cache-Control")
+	if len(lines) == 0 || seconds <= 0 {
+		return
+	}
+
+	result := make([]string, 0, len(lines))
+	for _, line := range lines {
+		parts := strings.Split(line, ",")
+		for i, part := range parts {
+			j := strings.Index(part, "=")
+			if j < 0 {
+				continue
+			}
+
+			name := strings.ToLower(strings.TrimSpace(part[:j]))
+			if name != "max-age" && name != "s-maxage" {
+				continue
+			}
+
+			value, err := strconv.Atoi(strings.Trim(strings.TrimSpace(part[j+1:]), `"`))
+			if err != nil {
+				continue
+			}
+
+			value -= seconds
+			if value < 0 {
+				value = 0
+			}
+
+			parts[i] = part[:j+1] + strconv.Itoa(value)
+		}
+
+		result = append(result, strings.Join(parts, ","))
+	}
+
+	header["Cache-Control"] = result
+}
+
+// newEntry creates the entry of the response without the body if the response can be stored.
+func (t *Transport) newEntry(k string, req *http.Request, resp *http.Response) *entry {
+	if !cacheableStatus[resp.StatusCode] || len(resp.Header.Values("Set-Cookie")) > 0 {
+		return nil
+	}
+
+	if resp.ContentLength > t.maxEntryBytes {
+		return nil
+	}
+
+	respDirectives := directives(resp.Header)
+	for _, name := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := respDirectives[name]; ok {
+			return nil
+		}
+	}
+
+	now := t.now()
+
+	var age time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Age")); err == nil && seconds > 0 {
+		age = time.Duration(seconds) * time.Second
+	}
+
+	// The freshness lifetime is taken from s-maxage, max-age or Expires, in that order.
+	var lifetime time.Duration
+	switch {
+	case respDirectives["s-maxage"] != "":
+		seconds, err := strconv.Atoi(respDirectives["s-maxage"])
+		if err != nil {
+			return nil
+		}
+		lifetime = time.Duration(seconds) * time.Second
+
+	case respDirectives["max-age"] != "":
+		seconds, err := strconv.Atoi(respDirectives["max-age"])
+		if err != nil {
+			return nil
+		}
+		lifetime = time.Duration(seconds) * time.Second
+
+	case resp.Header.Get("Expires") != "":
+		expires, err := http.ParseTime(resp.Header.Get("Expires"))
+		if err != nil {
+			return nil
+		}
+
+		date := now
+		if d, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+			date = d
+		}
+
+		lifetime = expires.Sub(date)
+
+	default:
+		return nil
+	}
+
+	if lifetime <= age {
+		return nil
+	}
+
+	e := &entry{
+		key:     k,
+		status:  resp.StatusCode,
+		header:  resp.Header.Clone(),
+		stored:  now,
+		age:     age,
+		expires: now.Add(lifetime - age)}
+
+	e.header.Del(Header)
+	e.header.Del("Age")
+
+	_, mustRevalidate := respDirectives["must-revalidate"]
+	_, proxyRevalidate := respDirectives["proxy-revalidate"]
+	e.mustRevalidate = mustRevalidate || proxyRevalidate
+
+	varyOn := resp.Header.Values("Vary")
+	if resp.Header.Get("Content-Encoding") != "" {
+		// The encoded responses must not be served to the clients which do not accept the encoding.
+		varyOn = append(varyOn, "Accept-Encoding")
+	}
+
+	for _, line := range varyOn {
+		for _, name := range strings.Split(line, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+
+			if name == "*" {
+				return nil
+			}
+
+			if e.vary == nil {
+				e.vary = make(map[string]string)
+			}
+			e.vary[http.CanonicalHeaderKey(name)] = strings.Join(req.Header.Values(name), ", ")
+		}
+	}
+
+	return e
+}
+
+// store stores the entry and evicts the least recently used ones beyond the maximum number of entries.
+func (t *Transport) store(e *entry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if el, ok := t.entries[e.key]; ok {
+		t.lru.Remove(el)
+	}
+	t.entries[e.key] = t.lru.PushFront(e)
+
+	for t.lru.Len() > t.maxEntries {
+		oldest := t.lru.Back()
+		t.lru.Remove(oldest)
+		delete(t.entries, oldest.Value.(*entry).key)
+	}
+}
+
+// recorder records the body while it is read and reports it once it has been read completely within the limit.
+type recorder struct {
+	body  io.ReadCloser
+	limit int64
+	buf   bytes.Buffer
+
+	// exceeded is set if the body is larger than the limit.
+	exceeded bool
+
+	done func(body []byte)
+}
+
+// Read implements io.Reader.
+func (r *recorder) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+
+	if !r.exceeded {
+		if int64(r.buf.Len()+n) > r.limit {
+			r.exceeded = true
+			r.buf = bytes.Buffer{}
+		} else {
+			r.buf.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF && !r.exceeded && r.done != nil {
+		r.done(r.buf.Bytes())
+		r.done = nil
+	}
+
+	return n, err
+}
+
+// Close implements io.Closer.
+func (r *recorder) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,67 @@
+package retry
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/Parquery/revproxyry/circuitbreaker"
+)
+
+// Idempotent checks whether the request can be safely retried, i.e., it is a GET or a HEAD without a body.
+//
+// The requests with a body are never retried since the body might have been consumed by the failed attempt.
+func Idempotent(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// Retryable checks whether the error indicates that the upstream could not be reached or dropped the connection
+// before responding, e.g., a dial error or a connection reset.
+//
+// The requests rejected by an open circuit are retryable since they have not been sent at all.
+//
+// The timeouts are not retryable since the upstream might still be processing the request.
+func Retryable(err error) bool {
+	if errors.Is(err, circuitbreaker.ErrOpen) {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Transport retries the idempotent requests on the retryable errors of the underlying transport.
+type Transport struct {
+	Transport http.RoundTripper
+
+	// Retries is the maximum number of retries after the first attempt.
+	Retries int
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+
+	for attempt := 0; attempt < t.Retries; attempt++ {
+		if err == nil || !Idempotent(req) || !Retryable(err) || req.Context().Err() != nil {
+			break
+		}
+
+		resp, err = t.Transport.RoundTrip(req)
+	}
+
+	return resp, err
+}
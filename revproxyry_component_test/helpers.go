@@ -0,0 +1,160 @@
+package main
+
+// helpers shared by the component tests.
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// testCert is a generated certificate together with its key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// tlsCertificate converts the certificate to the form used by crypto/tls.
+func (tc *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{tc.cert.Raw}, PrivateKey: tc.key, Leaf: tc.cert}
+}
+
+// writePEM writes the certificate and the key PEM-encoded to <dir>/<name>.crt and <dir>/<name>.key, respectively.
+func (tc *testCert) writePEM(dir string, name string) (certPath string, keyPath string, err error) {
+	certPath = filepath.Join(dir, name+".crt")
+	keyPath = filepath.Join(dir, name+".key")
+
+	err = ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tc.cert.Raw}), 0600)
+	if err != nil {
+		return "", "", err
+	}
+
+	der, err := x509.MarshalECPrivateKey(tc.key)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return "", "", err
+	}
+
+	return certPath, keyPath, nil
+}
+
+// newTestCert generates a certificate with the given serial number valid for localhost and 127.0.0.1.
+//
+// If issuer is nil, the certificate is a self-signed CA; otherwise it is signed by the issuer.
+// If client is set, the certificate is meant for TLS client authentication.
+func newTestCert(commonName string, serial int64, issuer *testCert, client bool) (*testCert, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the key: %s", err.Error())
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	switch {
+	case issuer == nil:
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+
+	case client:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	default:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.DNSNames = []string{"localhost"}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+
+	parent, signer := template, key
+	if issuer != nil {
+		parent, signer = issuer.cert, issuer.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the certificate: %s", err.Error())
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the certificate: %s", err.Error())
+	}
+
+	return &testCert{cert: cert, key: key}, nil
+}
+
+// startRevproxyry writes the config to the test directory, starts revproxyry with it and waits for it to start.
+//
+// The caller is expected to kill the returned process.
+func startRevproxyry(revproxyBinary string, testDir string, cfgTxt string) (*os.Process, error) {
+	cfgPth := filepath.Join(testDir, "config.json")
+	err := ioutil.WriteFile(cfgPth, []byte(cfgTxt), 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the config: %s", err.Error())
+	}
+
+	proc, err := os.StartProcess(
+		revproxyBinary,
+		[]string{revproxyBinary, "-config_path", cfgPth},
+		&os.ProcAttr{Files: []*os.File{os.Stdin, os.Stdout, os.Stderr}})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the process: %s", err.Error())
+	}
+
+	fmt.Println("Sleeping to allow the server to start...")
+	time.Sleep(3 * time.Second)
+
+	return proc, nil
+}
+
+// fetch sends a GET request with the given header and reads the whole body.
+//
+// The body is returned as sent by the server, i.e., a gzip-encoded body is not decompressed.
+func fetch(url string, header http.Header) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create the request for %s: %s", url, err.Error())
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch %s: %s", url, err.Error())
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the body of %s: %s", url, err.Error())
+	}
+
+	return response, body, nil
+}
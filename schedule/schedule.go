@@ -0,0 +1,125 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Parquery/revproxyry/config"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// window represents a parsed time window of an access schedule.
+type window struct {
+	// days on which the window applies. If empty, the window applies on all days.
+	days map[time.Weekday]bool
+
+	// from and to are given as minutes since midnight. If to is not after from, the window spans midnight.
+	from int
+	to   int
+}
+
+// parseClock parses the time of the day given as HH:MM and returns the minutes since midnight.
+func parseClock(text string) (int, error) {
+	t, err := time.Parse("15:04", text)
+	if err != nil {
+		return 0, fmt.Errorf("expected time of the day as HH:MM, got: %#v", text)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains checks whether the window contains the day of the week and the minutes since midnight.
+func (w *window) contains(day time.Weekday, minutes int) bool {
+	if w.from < w.to {
+		return (len(w.days) == 0 || w.days[day]) && w.from <= minutes && minutes < w.to
+	}
+
+	// The window spans midnight so that its latter part belongs to the day after.
+	if minutes >= w.from {
+		return len(w.days) == 0 || w.days[day]
+	}
+
+	if minutes < w.to {
+		return len(w.days) == 0 || w.days[(day+6)%7]
+	}
+
+	return false
+}
+
+// Schedule represents the time windows in which a route is accessible.
+type Schedule struct {
+	location *time.Location
+	windows  []*window
+}
+
+// New parses the access schedule specified in the config.
+func New(cfgSchedule *config.AccessSchedule) (s *Schedule, err error) {
+	s = &Schedule{}
+
+	s.location = time.UTC
+	if cfgSchedule.Timezone != "" {
+		s.location, err = time.LoadLocation(cfgSchedule.Timezone)
+		if err != nil {
+			err = fmt.Errorf("failed to load the timezone %#v: %s", cfgSchedule.Timezone, err.Error())
+			return
+		}
+	}
+
+	if len(cfgSchedule.Windows) == 0 {
+		err = fmt.Errorf("expected at least one window in the access schedule")
+		return
+	}
+
+	for i, cfgWindow := range cfgSchedule.Windows {
+		w := &window{days: make(map[time.Weekday]bool)}
+
+		for _, day := range cfgWindow.Days {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				err = fmt.Errorf("unknown day in the window %d of the access schedule: %#v", i, day)
+				return
+			}
+			w.days[weekday] = true
+		}
+
+		w.from, err = parseClock(cfgWindow.From)
+		if err != nil {
+			err = fmt.Errorf("invalid start of the window %d of the access schedule: %s", i, err.Error())
+			return
+		}
+
+		w.to, err = parseClock(cfgWindow.To)
+		if err != nil {
+			err = fmt.Errorf("invalid end of the window %d of the access schedule: %s", i, err.Error())
+			return
+		}
+
+		s.windows = append(s.windows, w)
+	}
+
+	return
+}
+
+// Allows checks whether the access is allowed at the given time.
+func (s *Schedule) Allows(t time.Time) bool {
+	local := t.In(s.location)
+	minutes := local.Hour()*60 + local.Minute()
+
+	for _, w := range s.windows {
+		if w.contains(local.Weekday(), minutes) {
+			return true
+		}
+	}
+
+	return false
+}
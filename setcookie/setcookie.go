@@ -0,0 +1,57 @@
+package setcookie
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Rewrite rewrites the Domain and Path attributes of a Set-Cookie header value.
+//
+// The Domain attribute is set to the given domain or removed if the domain is empty. The Path attribute is
+// prepended with the given prefix. All the other attributes are preserved as-are.
+func Rewrite(value string, domain string, prefix string) string {
+	parts := strings.Split(value, ";")
+
+	result := make([]string, 0, len(parts))
+	result = append(result, parts[0])
+
+	for _, part := range parts[1:] {
+		attr := strings.TrimSpace(part)
+
+		key := attr
+		val := ""
+		if i := strings.Index(attr, "="); i >= 0 {
+			key = attr[:i]
+			val = attr[i+1:]
+		}
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "domain":
+			if domain == "" {
+				continue
+			}
+			part = " Domain=" + domain
+
+		case "path":
+			joined := path.Join("/", prefix, strings.TrimSpace(val))
+			if strings.HasSuffix(val, "/") && !strings.HasSuffix(joined, "/") {
+				joined += "/"
+			}
+			part = " Path=" + joined
+		}
+
+		result = append(result, part)
+	}
+
+	return strings.Join(result, ";")
+}
+
+// RewriteResponse rewrites all the Set-Cookie headers of the response.
+func RewriteResponse(resp *http.Response, domain string, prefix string) {
+	values := resp.Header["Set-Cookie"]
+
+	for i, value := range values {
+		values[i] = Rewrite(value, domain, prefix)
+	}
+}
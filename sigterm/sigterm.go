@@ -23,3 +23,14 @@ func RegisterSIGTERMHandler() {
 func ReceivedSIGTERM() bool {
 	return atomic.LoadInt32(&receivedSIGTERM) > 0
 }
+
+// RegisterSIGHUPHandler registers the handler which is called on every SIGHUP signal.
+func RegisterSIGHUPHandler(handler func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			handler()
+		}
+	}()
+}
@@ -0,0 +1,55 @@
+package sni
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/Parquery/revproxyry/config"
+	"github.com/Parquery/revproxyry/tlscert"
+)
+
+// Selector selects the certificate by the server name indicated by the client.
+type Selector struct {
+	// byHost maps the lower-case host names, including the wildcards such as "*.example.com", to the reloaders
+	// of the certificates.
+	byHost map[string]*tlscert.Reloader
+}
+
+// New maps the host names of the certificates to their reloaders, where reloaders[i] serves certificates[i].
+func New(certificates []config.Certificate, reloaders []*tlscert.Reloader) (*Selector, error) {
+	if len(certificates) != len(reloaders) {
+		return nil, fmt.Errorf("expected a reloader for each of the %d certificate(s), but got %d",
+			len(certificates), len(reloaders))
+	}
+
+	s := &Selector{byHost: make(map[string]*tlscert.Reloader)}
+
+	for i, c := range certificates {
+		for _, hostname := range c.Hostnames {
+			s.byHost[strings.ToLower(hostname)] = reloaders[i]
+		}
+	}
+
+	return s, nil
+}
+
+// GetCertificate picks the certificate matching the server name either exactly or by a wildcard.
+//
+// If none matches, nil is returned so that the TLS stack falls back to the default certificate, if any.
+func (s *Selector) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	if r, ok := s.byHost[name]; ok {
+		return r.Certificate(), nil
+	}
+
+	// A wildcard covers exactly one label.
+	if i := strings.Index(name, "."); i > 0 {
+		if r, ok := s.byHost["*"+name[i:]]; ok {
+			return r.Certificate(), nil
+		}
+	}
+
+	return nil, nil
+}
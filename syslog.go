@@ -0,0 +1,38 @@
+//go:build !windows
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+
+	"github.com/Parquery/revproxyry/config"
+)
+
+// newSyslogWriters connects to the local syslog. The output is logged with the priority info and the errors
+// with the priority err.
+func newSyslogWriters(cfg *config.Config) (outWriter io.WriteCloser, errWriter io.WriteCloser, err error) {
+	facility := syslog.LOG_DAEMON
+	if code, ok := config.SyslogFacilities[cfg.SyslogFacility]; ok {
+		// The facility occupies the bits above the severity.
+		facility = syslog.Priority(code << 3)
+	}
+
+	tag := cfg.SyslogTag
+	if tag == "" {
+		tag = "revproxyry"
+	}
+
+	out, err := syslog.New(facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	errOut, err := syslog.New(facility|syslog.LOG_ERR, tag)
+	if err != nil {
+		out.Close()
+		return nil, nil, err
+	}
+
+	return out, errOut, nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"errors"
+	"io"
+
+	"github.com/Parquery/revproxyry/config"
+)
+
+// newSyslogWriters fails since Go provides no syslog client on Windows. config.Validate rejects the syslog
+// target beforehand.
+func newSyslogWriters(cfg *config.Config) (outWriter io.WriteCloser, errWriter io.WriteCloser, err error) {
+	return nil, nil, errors.New("the syslog is not supported on Windows")
+}
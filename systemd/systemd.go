@@ -0,0 +1,68 @@
+//go:build !windows
+
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd; 0, 1 and 2 are the standard streams.
+const listenFdsStart = 3
+
+// Listeners returns the listeners inherited through the socket activation of systemd, in the order of the
+// sockets in the socket unit.
+//
+// If the process has not been socket-activated (i.e., LISTEN_PID and LISTEN_FDS are not set for this process),
+// no listeners are returned. The environment variables are unset so that the child processes do not inherit them.
+func Listeners() ([]net.Listener, error) {
+	pidStr, hasPid := os.LookupEnv("LISTEN_PID")
+	fdsStr, hasFds := os.LookupEnv("LISTEN_FDS")
+	if !hasPid || !hasFds {
+		return nil, nil
+	}
+
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse LISTEN_PID: %#v", pidStr)
+	}
+
+	if pid != os.Getpid() {
+		// The variables were meant for another process, e.g., the parent.
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(fdsStr)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("failed to parse LISTEN_FDS: %#v", fdsStr)
+	}
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+
+		// The listener duplicates the file descriptor so that the file can be closed.
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to create a listener from the inherited file descriptor %d: %s",
+				fd, err.Error())
+		}
+
+		listeners = append(listeners, ln)
+	}
+
+	return listeners, nil
+}
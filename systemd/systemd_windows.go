@@ -0,0 +1,10 @@
+package systemd
+
+import (
+	"net"
+)
+
+// Listeners returns no listeners since there is no socket activation of systemd on Windows.
+func Listeners() ([]net.Listener, error) {
+	return nil, nil
+}
@@ -0,0 +1,103 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stamp identifies a version of the certificate and key files by their modification times and sizes.
+type stamp struct {
+	certModTime time.Time
+	certSize    int64
+	keyModTime  time.Time
+	keySize     int64
+}
+
+// Reloader serves a certificate loaded from its files and swaps it when the files change on disk
+// so that a renewed certificate is served without a restart.
+type Reloader struct {
+	CertFile   string
+	KeyFile    string
+	Passphrase string
+
+	cert atomic.Value // *tls.Certificate
+
+	mu    sync.Mutex // serializes the reloads
+	stamp stamp      // stamp of the files at the last successful load
+}
+
+// NewReloader creates a reloader of the certificate/key pair. The files are not loaded until the first Reload.
+func NewReloader(certFile string, keyFile string, passphrase string) *Reloader {
+	return &Reloader{CertFile: certFile, KeyFile: keyFile, Passphrase: passphrase}
+}
+
+// stat stamps the current version of the files.
+func (r *Reloader) stat() (stamp, error) {
+	certStat, err := os.Stat(r.CertFile)
+	if err != nil {
+		return stamp{}, err
+	}
+
+	keyStat, err := os.Stat(r.KeyFile)
+	if err != nil {
+		return stamp{}, err
+	}
+
+	return stamp{
+		certModTime: certStat.ModTime(),
+		certSize:    certStat.Size(),
+		keyModTime:  keyStat.ModTime(),
+		keySize:     keyStat.Size()}, nil
+}
+
+// Reload loads the files if they changed since the last successful load and swaps the served certificate.
+//
+// The loaded certificate is swapped only if check, when given, accepts it. On error, the previous certificate
+// is kept and the reload is attempted again on the next call. Reload returns true if the certificate was swapped.
+func (r *Reloader) Reload(check func(cert *tls.Certificate) error) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The files are stamped before they are read so that a change during the load is picked up on the next call.
+	st, err := r.stat()
+	if err != nil {
+		return false, err
+	}
+
+	if r.cert.Load() != nil && st == r.stamp {
+		return false, nil
+	}
+
+	cert, err := Load(r.CertFile, r.KeyFile, r.Passphrase)
+	if err != nil {
+		return false, fmt.Errorf("failed to load the certificate %s with the key %s: %s",
+			r.CertFile, r.KeyFile, err.Error())
+	}
+
+	if check != nil {
+		err = check(&cert)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	r.cert.Store(&cert)
+	r.stamp = st
+
+	return true, nil
+}
+
+// Certificate returns the currently served certificate, or nil if none has been loaded yet.
+func (r *Reloader) Certificate() *tls.Certificate {
+	cert, _ := r.cert.Load().(*tls.Certificate)
+	return cert
+}
+
+// GetCertificate returns the currently served certificate.
+func (r *Reloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
@@ -0,0 +1,223 @@
+package tlscert
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ErrIncorrectPassphrase indicates that the encrypted key could not be decrypted with the passphrase.
+var ErrIncorrectPassphrase = errors.New("incorrect passphrase of the encrypted key")
+
+// Load loads the certificate with its key as tls.LoadX509KeyPair does.
+//
+// If the key is encrypted either with the legacy PEM encryption ("Proc-Type: 4,ENCRYPTED") or as an encrypted
+// PKCS #8 key ("ENCRYPTED PRIVATE KEY"), it is decrypted with the passphrase.
+func Load(certFile string, keyFile string, passphrase string) (tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	keyPEM, err = decryptKey(keyPEM, passphrase)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to decrypt the key %s: %s", keyFile, err.Error())
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// decryptKey decrypts the first private key in the PEM data, if encrypted, and returns it PEM-encoded.
+// The unencrypted keys are returned as-are.
+func decryptKey(keyPEM []byte, passphrase string) ([]byte, error) {
+	rest := keyPEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			// Let tls.X509KeyPair report the missing key.
+			return keyPEM, nil
+		}
+
+		switch {
+		case block.Type == "ENCRYPTED PRIVATE KEY":
+			if passphrase == "" {
+				return nil, fmt.Errorf("the key is encrypted, but no passphrase was given")
+			}
+
+			der, err := decryptPKCS8(block.Bytes, []byte(passphrase))
+			if err != nil {
+				return nil, err
+			}
+
+			return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+
+		case x509.IsEncryptedPEMBlock(block):
+			// The legacy encryption is deprecated as insecure, but still produced by some tools.
+			if passphrase == "" {
+				return nil, fmt.Errorf("the key is encrypted, but no passphrase was given")
+			}
+
+			der, err := x509.DecryptPEMBlock(block, []byte(passphrase))
+			if err != nil {
+				if err == x509.IncorrectPasswordError {
+					return nil, ErrIncorrectPassphrase
+				}
+				return nil, err
+			}
+
+			return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			return keyPEM, nil
+		}
+	}
+}
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// encryptedPrivateKeyInfo is defined in RFC 5958.
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params are defined in RFC 8018.
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are defined in RFC 8018. The salt is restricted to the specified octet string.
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 decrypts an encrypted PKCS #8 key with PBES2, PBKDF2 and AES-CBC, the default of OpenSSL.
+func decryptPKCS8(der []byte, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	_, err := asn1.Unmarshal(der, &info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the encrypted PKCS #8 key: %s", err.Error())
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported encryption of the PKCS #8 key %s, only PBES2 is supported",
+			info.Algorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	_, err = asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the PBES2 parameters: %s", err.Error())
+	}
+
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function %s, only PBKDF2 is supported",
+			params.KeyDerivationFunc.Algorithm)
+	}
+
+	var kdfParams pbkdf2Params
+	_, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the PBKDF2 parameters: %s", err.Error())
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdfParams.PRF.Algorithm) == 0 || kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported pseudo-random function of PBKDF2: %s", kdfParams.PRF.Algorithm)
+	}
+
+	var keyLen int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLen = 16
+	case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLen = 24
+	case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("unsupported encryption scheme %s, only AES-CBC is supported",
+			params.EncryptionScheme.Algorithm)
+	}
+
+	var iv []byte
+	_, err = asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv)
+	if err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid initialization vector of the encryption scheme")
+	}
+
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid length of the encrypted key: %d", len(info.EncryptedData))
+	}
+
+	key := pbkdf2.Key(passphrase, kdfParams.Salt, kdfParams.IterationCount, keyLen, prf)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.EncryptedData)
+
+	// A wrong passphrase results in garbage which almost always fails the padding or the parsing.
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, ErrIncorrectPassphrase
+	}
+	for _, b := range plain[len(plain)-padding:] {
+		if int(b) != padding {
+			return nil, ErrIncorrectPassphrase
+		}
+	}
+	plain = plain[:len(plain)-padding]
+
+	_, err = x509.ParsePKCS8PrivateKey(plain)
+	if err != nil {
+		return nil, ErrIncorrectPassphrase
+	}
+
+	return plain, nil
+}
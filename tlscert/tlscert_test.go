@@ -0,0 +1,75 @@
+package tlscert
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The fixtures in testdata were generated with OpenSSL 3:
+//
+//	openssl ecparam -name prime256v1 -genkey -noout -out ec.pem
+//	openssl pkcs8 -topk8 -nocrypt -in ec.pem -out key.pem
+//	openssl req -new -x509 -key key.pem -subj /CN=localhost -days 36500 -out cert.pem
+//	openssl pkcs8 -topk8 -in key.pem -v2 aes-256-cbc -v2prf hmacWithSHA256 \
+//	    -passout pass:some-passphrase -out key-pkcs8-sha256.pem
+//	openssl pkcs8 -topk8 -in key.pem -v2 aes-128-cbc -v2prf hmacWithSHA1 \
+//	    -passout pass:some-passphrase -out key-pkcs8-sha1.pem
+//	openssl ec -in ec.pem -aes256 -passout pass:some-passphrase -out key-legacy.pem
+//
+// OpenSSL omits the PRF of PBKDF2 if it is the default hmacWithSHA1.
+
+const passphrase = "some-passphrase"
+
+func TestLoad(t *testing.T) {
+	certFile := filepath.Join("testdata", "cert.pem")
+
+	tests := []struct {
+		name        string
+		keyFile     string
+		passphrase  string
+		expectedErr string
+	}{
+		{name: "unencrypted", keyFile: "key.pem"},
+		{name: "unencrypted with a passphrase", keyFile: "key.pem", passphrase: passphrase},
+		{name: "PKCS #8 with hmacWithSHA256", keyFile: "key-pkcs8-sha256.pem", passphrase: passphrase},
+		{name: "PKCS #8 with the default hmacWithSHA1", keyFile: "key-pkcs8-sha1.pem", passphrase: passphrase},
+		{name: "legacy PEM encryption", keyFile: "key-legacy.pem", passphrase: passphrase},
+		{name: "PKCS #8 with a wrong passphrase", keyFile: "key-pkcs8-sha256.pem", passphrase: "wrong",
+			expectedErr: ErrIncorrectPassphrase.Error()},
+		{name: "legacy PEM encryption with a wrong passphrase", keyFile: "key-legacy.pem", passphrase: "wrong",
+			expectedErr: ErrIncorrectPassphrase.Error()},
+		{name: "PKCS #8 without a passphrase", keyFile: "key-pkcs8-sha1.pem",
+			expectedErr: "the key is encrypted, but no passphrase was given"},
+		{name: "legacy PEM encryption without a passphrase", keyFile: "key-legacy.pem",
+			expectedErr: "the key is encrypted, but no passphrase was given"},
+	}
+
+	for _, test := range tests {
+		cert, err := Load(certFile, filepath.Join("testdata", test.keyFile), test.passphrase)
+
+		switch {
+		case test.expectedErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+
+		case test.expectedErr == "" && cert.PrivateKey == nil:
+			t.Errorf("%s: expected the private key to be loaded", test.name)
+
+		case test.expectedErr != "" && err == nil:
+			t.Errorf("%s: expected the error %#v, got none", test.name, test.expectedErr)
+
+		case test.expectedErr != "" && !strings.HasSuffix(err.Error(), test.expectedErr):
+			t.Errorf("%s: expected the error %#v, got %#v", test.name, test.expectedErr, err.Error())
+		}
+	}
+}
+
+func TestLoadMismatchedKey(t *testing.T) {
+	// The certificate serves as a key file without any private key.
+	certFile := filepath.Join("testdata", "cert.pem")
+
+	_, err := Load(certFile, certFile, passphrase)
+	if err == nil {
+		t.Errorf("expected an error if the key file contains no private key")
+	}
+}
@@ -0,0 +1,79 @@
+package upstream
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// address determines the TCP address (host:port) of the upstream given as an URL.
+func address(u *url.URL) (string, error) {
+	if u.Host == "" {
+		return "", fmt.Errorf("unexpected empty host in the upstream URL: %s", u.String())
+	}
+
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+
+	switch u.Scheme {
+	case "http":
+		return net.JoinHostPort(u.Hostname(), "80"), nil
+	case "https":
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	default:
+		return "", fmt.Errorf("could not determine the port of the upstream URL: %s", u.String())
+	}
+}
+
+// WaitFor polls the given upstream URLs until all of them accept TCP connections or the timeout elapses.
+//
+// The progress is logged to logOut. If the timeout elapses, the upstreams which are still unreachable are
+// returned so that the caller can decide what to do with them.
+func WaitFor(targets []*url.URL, timeout time.Duration, logOut *log.Logger) (unreachable []string, err error) {
+	pending := make(map[string]bool)
+	for _, u := range targets {
+		var addr string
+		addr, err = address(u)
+		if err != nil {
+			return
+		}
+		pending[addr] = true
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		for addr := range pending {
+			conn, dialErr := net.DialTimeout("tcp", addr, time.Second)
+			if dialErr != nil {
+				continue
+			}
+			conn.Close()
+
+			logOut.Printf("Upstream is reachable: %s\n", addr)
+			delete(pending, addr)
+		}
+
+		if len(pending) == 0 {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			for addr := range pending {
+				unreachable = append(unreachable, addr)
+			}
+			sort.Strings(unreachable)
+			return
+		}
+
+		for addr := range pending {
+			logOut.Printf("Waiting for the upstream to become reachable: %s\n", addr)
+		}
+
+		time.Sleep(time.Second)
+	}
+}